@@ -0,0 +1,268 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package issues
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// blameHit is one commit touching a specific file:line, as reported by
+// either GitHub's blame GraphQL endpoint or local `git blame`.
+type blameHit struct {
+	Author string
+	When   time.Time
+}
+
+// blameCandidate is an (author, score) pair, in descending score order.
+type blameCandidate struct {
+	Author string
+	Score  float64
+}
+
+// blameHalfLife is the recency decay period used to weight blame hits: a
+// line blamed 90 days ago counts for half as much as one blamed today.
+const blameHalfLife = 90 * 24 * time.Hour
+
+// blameAssigner attributes a failure to the engineer most likely
+// responsible for it, based on git blame of the in-repo stack frames
+// mentioned in the failure message, weighted by how recently each blamed
+// line was touched. This is far less noisy on large packages (pkg/sql,
+// pkg/kv) than IssueTracker.ListCommits' "who last committed to the whole
+// package" heuristic, which Assign's caller falls back to when Assign yields
+// no candidates (e.g. the message contains no in-repo frames at all).
+type blameAssigner struct {
+	token         string
+	localCheckout string
+	do            func(*http.Request) (*http.Response, error)
+}
+
+// newBlameAssigner constructs a blameAssigner using token for the GitHub
+// blame GraphQL endpoint. If LOCAL_CHECKOUT_PATH is set, `git blame` against
+// that checkout is used instead, so engineers testing this locally don't
+// need a token or to spend GitHub API quota.
+func newBlameAssigner(token string) *blameAssigner {
+	return &blameAssigner{
+		token:         token,
+		localCheckout: os.Getenv("LOCAL_CHECKOUT_PATH"),
+		do:            http.DefaultClient.Do,
+	}
+}
+
+// Assign returns blame candidates for the in-repo file:line references
+// found in message, highest-scoring first. It returns (nil, nil) when
+// message contains no in-repo frames, signaling the caller to fall back to
+// IssueTracker.ListCommits instead.
+func (b *blameAssigner) Assign(ctx context.Context, owner, repo, message string) ([]blameCandidate, error) {
+	frames := allStackFrames(message)
+	if len(frames) == 0 {
+		return nil, nil
+	}
+
+	now := time.Now()
+	scores := map[string]float64{}
+	for _, frame := range frames {
+		hits, err := b.blame(ctx, owner, repo, frame)
+		if err != nil {
+			return nil, fmt.Errorf("blaming %s: %w", frame, err)
+		}
+		for _, h := range hits {
+			age := now.Sub(h.When)
+			weight := math.Pow(0.5, age.Hours()/blameHalfLife.Hours())
+			scores[h.Author] += weight
+		}
+	}
+
+	candidates := make([]blameCandidate, 0, len(scores))
+	for author, score := range scores {
+		candidates = append(candidates, blameCandidate{Author: author, Score: score})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Score != candidates[j].Score {
+			return candidates[i].Score > candidates[j].Score
+		}
+		return candidates[i].Author < candidates[j].Author
+	})
+	return candidates, nil
+}
+
+// blame returns the blame hit for a single "pkg/foo/bar.go:123" frame.
+func (b *blameAssigner) blame(ctx context.Context, owner, repo, frame string) ([]blameHit, error) {
+	file, line, err := splitFrame(frame)
+	if err != nil {
+		return nil, err
+	}
+	if b.localCheckout != "" {
+		return b.blameLocal(ctx, file, line)
+	}
+	return b.blameGitHub(ctx, owner, repo, file, line)
+}
+
+func splitFrame(frame string) (file string, line int, err error) {
+	i := strings.LastIndex(frame, ":")
+	if i < 0 {
+		return "", 0, fmt.Errorf("malformed frame %q", frame)
+	}
+	line, err = strconv.Atoi(frame[i+1:])
+	if err != nil {
+		return "", 0, fmt.Errorf("malformed frame %q: %w", frame, err)
+	}
+	return frame[:i], line, nil
+}
+
+// blameLocal shells out to `git blame` in localCheckout.
+func (b *blameAssigner) blameLocal(ctx context.Context, file string, line int) ([]blameHit, error) {
+	rng := fmt.Sprintf("%d,%d", line, line)
+	cmd := exec.CommandContext(ctx, "git", "blame", "-L", rng, "--porcelain", file)
+	cmd.Dir = b.localCheckout
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	var author string
+	var when time.Time
+	for _, l := range strings.Split(string(out), "\n") {
+		switch {
+		case strings.HasPrefix(l, "author "):
+			author = strings.TrimPrefix(l, "author ")
+		case strings.HasPrefix(l, "author-time "):
+			if sec, err := strconv.ParseInt(strings.TrimPrefix(l, "author-time "), 10, 64); err == nil {
+				when = time.Unix(sec, 0)
+			}
+		}
+	}
+	if author == "" {
+		return nil, nil
+	}
+	return []blameHit{{Author: author, When: when}}, nil
+}
+
+// githubBlameQuery asks for the authorship of every line of a file via
+// GitHub's blame GraphQL API (the REST API has no blame endpoint).
+const githubBlameQuery = `query($owner:String!,$repo:String!,$expr:String!,$path:String!) {
+  repository(owner:$owner, name:$repo) {
+    object(expression:$expr) {
+      ... on Commit {
+        blame(path:$path) {
+          ranges { startingLine endingLine commit { author { name } committedDate } }
+        }
+      }
+    }
+  }
+}`
+
+func (b *blameAssigner) blameGitHub(ctx context.Context, owner, repo, file string, line int) ([]blameHit, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"query": githubBlameQuery,
+		"variables": map[string]string{
+			"owner": owner,
+			"repo":  repo,
+			"expr":  "HEAD",
+			"path":  file,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "https://api.github.com/graphql", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+b.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := b.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("github blame: status %d", resp.StatusCode)
+	}
+	var out struct {
+		Data struct {
+			Repository struct {
+				Object struct {
+					Blame struct {
+						Ranges []struct {
+							StartingLine int
+							EndingLine   int
+							Commit       struct {
+								Author struct {
+									Name string
+								}
+								CommittedDate time.Time
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, err
+	}
+	for _, r := range out.Data.Repository.Object.Blame.Ranges {
+		if line >= r.StartingLine && line <= r.EndingLine {
+			return []blameHit{{Author: r.Commit.Author.Name, When: r.Commit.CommittedDate}}, nil
+		}
+	}
+	return nil, nil
+}
+
+// renderBlameCandidates renders candidates into the collapsible "Blame
+// candidates" block embedded in an issue body, so humans reviewing the
+// filed issue can override the automatic assignee choice.
+func renderBlameCandidates(candidates []blameCandidate) string {
+	if len(candidates) == 0 {
+		return ""
+	}
+	var buf strings.Builder
+	buf.WriteString("<details><summary>Blame candidates</summary>\n\n")
+	for _, c := range candidates {
+		fmt.Fprintf(&buf, "- @%s (score %.2f)\n", c.Author, c.Score)
+	}
+	buf.WriteString("\n</details>")
+	return buf.String()
+}
+
+// chooseAssignee picks an assignee for req, preferring blame candidates
+// derived from in-repo stack frames in the failure message. It falls back
+// to the assignee of the most recent commit touching the package (via
+// IssueTracker.ListCommits, which every backend implements) when the
+// message contains no such frames, or when p.blame is nil because the
+// backend doesn't use GitHub's blame GraphQL API.
+func (p *poster) chooseAssignee(ctx context.Context, req PostRequest) (assignee, blameBlock string, err error) {
+	var candidates []blameCandidate
+	if p.blame != nil {
+		candidates, err = p.blame.Assign(ctx, p.Org, p.Repo, req.Message)
+		if err != nil {
+			return "", "", err
+		}
+	}
+	if len(candidates) == 0 {
+		commits, err := p.tracker.ListCommits(ctx, p.Org, p.Repo, repoPath(req.PackageName, p.Org, p.Repo))
+		if err != nil || len(commits) == 0 {
+			return "", "", err
+		}
+		return commits[0].AuthorName, "", nil
+	}
+	return candidates[0].Author, renderBlameCandidates(candidates), nil
+}
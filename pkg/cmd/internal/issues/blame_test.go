@@ -0,0 +1,153 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package issues
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeBlameRange fabricates one line range of a GitHub blame GraphQL
+// response.
+type fakeBlameRange struct {
+	Start, End int
+	Author     string
+	When       time.Time
+}
+
+// fakeBlameTransport serves canned blame GraphQL responses keyed by the
+// requested file path, standing in for GitHub's blame endpoint in tests.
+func fakeBlameTransport(t *testing.T, byFile map[string][]fakeBlameRange) func(*http.Request) (*http.Response, error) {
+	return func(req *http.Request) (*http.Response, error) {
+		var body struct {
+			Variables struct {
+				Path string `json:"path"`
+			} `json:"variables"`
+		}
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&body))
+
+		ranges := byFile[body.Variables.Path]
+		var out struct {
+			Data struct {
+				Repository struct {
+					Object struct {
+						Blame struct {
+							Ranges []struct {
+								StartingLine int
+								EndingLine   int
+								Commit       struct {
+									Author struct {
+										Name string
+									}
+									CommittedDate time.Time
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+		for _, r := range ranges {
+			var entry struct {
+				StartingLine int
+				EndingLine   int
+				Commit       struct {
+					Author struct {
+						Name string
+					}
+					CommittedDate time.Time
+				}
+			}
+			entry.StartingLine, entry.EndingLine = r.Start, r.End
+			entry.Commit.Author.Name = r.Author
+			entry.Commit.CommittedDate = r.When
+			out.Data.Repository.Object.Blame.Ranges = append(out.Data.Repository.Object.Blame.Ranges, entry)
+		}
+		buf, err := json.Marshal(out)
+		require.NoError(t, err)
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(bytes.NewReader(buf))}, nil
+	}
+}
+
+func TestBlameAssignerWeightsByRecency(t *testing.T) {
+	now := time.Now()
+	assigner := &blameAssigner{token: "fake", do: fakeBlameTransport(t, map[string][]fakeBlameRange{
+		"pkg/sql/conn.go": {
+			{Start: 1, End: 50, Author: "alice", When: now.Add(-200 * 24 * time.Hour)}, // old: decayed a lot
+			{Start: 51, End: 100, Author: "bob", When: now},                            // fresh: full weight
+		},
+	})}
+
+	// One line from each author, alice's first: only the recency weighting
+	// puts bob ahead.
+	message := "boom at pkg/sql/conn.go:10\n\tpkg/sql/conn.go:75"
+	candidates, err := assigner.Assign(context.Background(), "cockroachdb", "cockroach", message)
+	require.NoError(t, err)
+	require.Len(t, candidates, 2)
+	require.Equal(t, "bob", candidates[0].Author)
+}
+
+func TestBlameAssignerNoFramesFallsBack(t *testing.T) {
+	assigner := &blameAssigner{token: "fake", do: fakeBlameTransport(t, nil)}
+	candidates, err := assigner.Assign(context.Background(), "cockroachdb", "cockroach", "pure timeout, no stack trace")
+	require.NoError(t, err)
+	require.Nil(t, candidates)
+}
+
+func TestChooseAssigneeFallsBackToListCommitsWithoutFrames(t *testing.T) {
+	var buf strings.Builder
+	tracker := &fakeTracker{buf: &buf, assignee: "carol"}
+	p := &poster{
+		Options: &Options{Org: "cockroachdb", Repo: "cockroach"},
+		tracker: tracker,
+		blame:   &blameAssigner{token: "fake", do: fakeBlameTransport(t, nil)},
+	}
+	assignee, block, err := p.chooseAssignee(context.Background(), PostRequest{
+		PackageName: "github.com/cockroachdb/cockroach/pkg/sql",
+		Message:     "timed out waiting for condition",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "carol", assignee)
+	require.Empty(t, block)
+	// ListCommits takes a repo-relative path, not req.PackageName's full Go
+	// import path.
+	require.Contains(t, buf.String(), "path=pkg/sql\n")
+	require.NotContains(t, buf.String(), "path=github.com")
+}
+
+// TestChooseAssigneeFallsBackToListCommitsWithoutBlame covers non-GitHub
+// providers, for which Post leaves p.blame nil since blameAssigner only
+// understands GitHub's blame GraphQL API: chooseAssignee must still assign
+// via IssueTracker.ListCommits rather than skip assignment entirely.
+func TestChooseAssigneeFallsBackToListCommitsWithoutBlame(t *testing.T) {
+	var buf strings.Builder
+	tracker := &fakeTracker{buf: &buf, assignee: "carol"}
+	p := &poster{
+		Options: &Options{Org: "cockroachdb", Repo: "cockroach", Provider: "gitlab"},
+		tracker: tracker,
+	}
+	assignee, block, err := p.chooseAssignee(context.Background(), PostRequest{
+		PackageName: "github.com/cockroachdb/cockroach/pkg/sql",
+		Message:     "boom at pkg/sql/conn.go:75",
+	})
+	require.NoError(t, err)
+	require.Equal(t, "carol", assignee)
+	require.Empty(t, block)
+	require.Contains(t, buf.String(), "path=pkg/sql\n")
+}
@@ -0,0 +1,163 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package issues
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const fingerprintLen = 12
+
+var (
+	ansiRE       = regexp.MustCompile("\x1b\\[[0-9;]*[a-zA-Z]")
+	timestampRE  = regexp.MustCompile(`\b\d{6}\s+\d{2}:\d{2}:\d{2}\.\d+\b|\b\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:?\d{2})?\b`)
+	hexAddrRE    = regexp.MustCompile(`\b0x[0-9a-fA-F]{4,}\b`)
+	goroutineRE  = regexp.MustCompile(`\bgoroutine \d+\b`)
+	goSrcPathRE  = regexp.MustCompile(`/go/src/[^\s:]+`)
+	artifactsRE  = regexp.MustCompile(`artifacts/log\S*`)
+	stackFrameRE = regexp.MustCompile(`(pkg/[^\s:]+\.go):(\d+)`)
+)
+
+// normalizeMessage strips the parts of a failure message that vary from run
+// to run without changing what actually failed: ANSI color codes,
+// timestamps, heap addresses, goroutine numbers, and absolute paths under
+// /go/src/... or artifacts/log*. What's left is stable enough to fingerprint
+// or fuzzy-compare across otherwise-identical flaky failures.
+func normalizeMessage(msg string) string {
+	msg = ansiRE.ReplaceAllString(msg, "")
+	msg = timestampRE.ReplaceAllString(msg, "<ts>")
+	msg = hexAddrRE.ReplaceAllString(msg, "<addr>")
+	msg = goroutineRE.ReplaceAllString(msg, "goroutine <n>")
+	msg = goSrcPathRE.ReplaceAllString(msg, "<gopath>")
+	msg = artifactsRE.ReplaceAllString(msg, "artifacts/<log>")
+	return strings.TrimSpace(msg)
+}
+
+// topStackFrames returns the first n distinct in-repo (pkg/...) file:line
+// references found in message, in order of appearance. n <= 0 means
+// unlimited.
+func topStackFrames(message string, n int) []string {
+	seen := map[string]bool{}
+	var frames []string
+	for _, m := range stackFrameRE.FindAllStringSubmatch(message, -1) {
+		frame := m[1] + ":" + m[2]
+		if seen[frame] {
+			continue
+		}
+		seen[frame] = true
+		frames = append(frames, frame)
+		if n > 0 && len(frames) == n {
+			break
+		}
+	}
+	return frames
+}
+
+// allStackFrames returns every distinct in-repo (pkg/...) file:line
+// reference found in message, in order of appearance.
+func allStackFrames(message string) []string {
+	return topStackFrames(message, 0)
+}
+
+// Fingerprint computes a stable identifier for a failure, based on the
+// package, test name and the first few in-repo stack frames found in
+// message. Two runs of the same flaky test that differ only in timing,
+// goroutine ids or addresses hash to the same fingerprint.
+func Fingerprint(packageName, testName, message string) string {
+	// Frames must be extracted from the raw message: normalizeMessage's
+	// goSrcPathRE replaces the whole /go/src/.../pkg/foo.go path (the pkg/...
+	// suffix included) with <gopath>, so by the time a normalized message
+	// reaches stackFrameRE, the absolute-path form used by real stack traces
+	// has already lost the frame it's looking for.
+	frames := topStackFrames(message, 3)
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s", packageName, testName, strings.Join(frames, "\n"))
+	return hex.EncodeToString(h.Sum(nil))[:fingerprintLen]
+}
+
+// fingerprintMarkerRE recognizes the HTML-comment fingerprint marker this
+// package embeds in an issue body on creation.
+var fingerprintMarkerRE = regexp.MustCompile(`<!-- fingerprint: ([0-9a-f]+) -->`)
+
+// fingerprintComment renders the HTML-comment marker embedded in an issue
+// body so that a later post for the same failure can find it again via
+// `in:body` search, even once the visible message text has drifted.
+func fingerprintComment(fingerprint string) string {
+	return fmt.Sprintf("<!-- fingerprint: %s -->", fingerprint)
+}
+
+// parseFingerprintMarker extracts the fingerprint embedded in an issue body
+// by a previous post, if any. Issues filed before this package started
+// stamping fingerprints don't have one.
+func parseFingerprintMarker(body string) (string, bool) {
+	m := fingerprintMarkerRE.FindStringSubmatch(body)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// similarity returns the normalized Levenshtein similarity between a and b,
+// in [0, 1], where 1 means identical.
+func similarity(a, b string) float64 {
+	if a == "" && b == "" {
+		return 1
+	}
+	dist := levenshtein(a, b)
+	maxLen := len(a)
+	if len(b) > maxLen {
+		maxLen = len(b)
+	}
+	if maxLen == 0 {
+		return 1
+	}
+	return 1 - float64(dist)/float64(maxLen)
+}
+
+// levenshtein computes the edit distance between a and b.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			del := prev[j] + 1
+			ins := curr[j-1] + 1
+			sub := prev[j-1] + cost
+			curr[j] = min3(del, ins, sub)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	m := a
+	if b < m {
+		m = b
+	}
+	if c < m {
+		m = c
+	}
+	return m
+}
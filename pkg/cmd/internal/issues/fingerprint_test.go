@@ -0,0 +1,88 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package issues
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestFingerprintStableAcrossDrift verifies that two failures of the same
+// test that differ only in timing, goroutine ids and addresses produce the
+// same fingerprint, while a failure at a genuinely different call site does
+// not.
+func TestFingerprintStableAcrossDrift(t *testing.T) {
+	const pkg, test = "github.com/cockroachdb/cockroach/pkg/storage", "TestFoo"
+
+	first := `F220101 01:02:03.123456 42 pkg/storage/replica.go:100  boom
+goroutine 7 [running]:
+	pkg/storage/replica.go:100 +0x1a2b
+	pkg/storage/store.go:55 +0xdead`
+
+	second := `F230517 09:33:11.000001 9001 pkg/storage/replica.go:100  boom
+goroutine 413 [running]:
+	pkg/storage/replica.go:100 +0xbeef
+	pkg/storage/store.go:55 +0x1234`
+
+	different := `F230517 09:33:11.000001 9001 pkg/storage/other.go:12  boom
+goroutine 413 [running]:
+	pkg/storage/other.go:12 +0xbeef`
+
+	require.Equal(t, Fingerprint(pkg, test, first), Fingerprint(pkg, test, second))
+	require.NotEqual(t, Fingerprint(pkg, test, first), Fingerprint(pkg, test, different))
+}
+
+// TestFingerprintStableAcrossDriftWithAbsolutePaths is like
+// TestFingerprintStableAcrossDrift but uses the realistic absolute-path
+// stack trace form CI actually produces (/go/src/.../pkg/foo.go:123), which
+// normalizeMessage's goSrcPathRE collapses to <gopath> wholesale. Frame
+// extraction must happen before that normalization runs, or every such
+// failure fingerprints as if it had no in-repo frames at all.
+func TestFingerprintStableAcrossDriftWithAbsolutePaths(t *testing.T) {
+	const pkg, test = "github.com/cockroachdb/cockroach/pkg/storage", "TestFoo"
+
+	first := `F220101 01:02:03.123456 42 /go/src/github.com/cockroachdb/cockroach/pkg/storage/replica.go:100  boom
+goroutine 7 [running]:
+	/go/src/github.com/cockroachdb/cockroach/pkg/storage/replica.go:100 +0x1a2b`
+
+	second := `F230517 09:33:11.000001 9001 /go/src/github.com/cockroachdb/cockroach/pkg/storage/replica.go:100  boom
+goroutine 413 [running]:
+	/go/src/github.com/cockroachdb/cockroach/pkg/storage/replica.go:100 +0xbeef`
+
+	different := `F230517 09:33:11.000001 9001 /go/src/github.com/cockroachdb/cockroach/pkg/storage/other.go:12  boom
+goroutine 413 [running]:
+	/go/src/github.com/cockroachdb/cockroach/pkg/storage/other.go:12 +0xbeef`
+
+	fp := Fingerprint(pkg, test, first)
+	require.Equal(t, fp, Fingerprint(pkg, test, second))
+	require.NotEqual(t, fp, Fingerprint(pkg, test, different))
+
+	// A message with no in-repo frames at all is the only case that should
+	// fall back to the bare (package, test) fingerprint.
+	noFrames := Fingerprint(pkg, test, "boom, no stack trace here")
+	require.NotEqual(t, fp, noFrames)
+}
+
+func TestParseFingerprintMarker(t *testing.T) {
+	fp, ok := parseFingerprintMarker("some body\n" + fingerprintComment("abc123def456") + "\nmore")
+	require.True(t, ok)
+	require.Equal(t, "abc123def456", fp)
+
+	_, ok = parseFingerprintMarker("a legacy issue with no marker")
+	require.False(t, ok)
+}
+
+func TestSimilarity(t *testing.T) {
+	require.Equal(t, 1.0, similarity("abc", "abc"))
+	require.Greater(t, similarity("condition failed: not balanced [1 2 3]", "condition failed: not balanced [1 2 4]"), 0.85)
+	require.Less(t, similarity("completely different failure", "totally unrelated panic"), 0.85)
+}
@@ -0,0 +1,402 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+// Package issues centralizes the logic used by CI to turn failing test runs
+// into tracked issues (and, conversely, to keep already-filed issues up to
+// date instead of spamming the tracker with duplicates).
+package issues
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Labels that get attached to every issue this package files, regardless of
+// backend.
+const (
+	testFailureLabel = "C-test-failure"
+	robotLabel       = "O-robot"
+)
+
+// Options groups together the configuration needed to post (or update) an
+// issue about a failing test run. Most fields are populated from the CI
+// environment; see Post.
+type Options struct {
+	Token       string
+	Org         string
+	Repo        string
+	SHA         string
+	BuildTypeID string
+	BuildID     string
+	ServerURL   string
+	Branch      string
+	Tags        string
+	Goflags     string
+
+	// Provider selects which issue-tracker backend to post to. Empty means
+	// "github", which remains the default for backwards compatibility.
+	Provider string
+
+	// Notifiers are told about the outcome of every post, in addition to
+	// the issue tracker itself. Populated by OptionsFromEnv; tests set it
+	// directly.
+	Notifiers []Notifier
+
+	// getLatestTag is mocked out in tests.
+	getLatestTag func() (string, error)
+}
+
+// HelpCommand renders the "how to reproduce this failure" section of an
+// issue body.
+type HelpCommand interface {
+	Render() string
+}
+
+type unitTestHelpCommand string
+
+// Render implements HelpCommand.
+func (c unitTestHelpCommand) Render() string {
+	if c == "" {
+		return ""
+	}
+	return "```\n" + string(c) + "\n```"
+}
+
+// UnitTestHelpCommand returns a HelpCommand that tells the reader to run the
+// given shell command to reproduce a unit test failure locally.
+func UnitTestHelpCommand(cmd string) HelpCommand {
+	return unitTestHelpCommand(cmd)
+}
+
+type linkHelpCommand struct {
+	title, url string
+}
+
+// Render implements HelpCommand.
+func (c linkHelpCommand) Render() string {
+	return fmt.Sprintf("[%s](%s)", c.title, c.url)
+}
+
+// HelpCommandAsLink returns a HelpCommand that points the reader at an
+// external document (e.g. a roachtest README) instead of a shell command.
+func HelpCommandAsLink(title, url string) HelpCommand {
+	return linkHelpCommand{title: title, url: url}
+}
+
+// PostRequest captures everything needed to file or update an issue tracking
+// a single test failure.
+type PostRequest struct {
+	// PackageName is the Go import path of the package under test, e.g.
+	// "github.com/cockroachdb/cockroach/pkg/storage".
+	PackageName string
+	// TestName is the name of the failing test.
+	TestName string
+	// Message is the test output (or relevant excerpt of it) that triggered
+	// the post.
+	Message string
+	// Artifacts, if set, is a relative path (under the build's artifacts
+	// directory) to further material worth linking from the issue.
+	Artifacts string
+	// MentionOnCreate lists GitHub-style handles to @-mention when a new
+	// issue is created (but not on subsequent comments).
+	MentionOnCreate []string
+	// HelpCommand renders the repro instructions included in the issue body.
+	HelpCommand HelpCommand
+	// ExtraLabels are added to the label set on top of testFailureLabel,
+	// robotLabel and the branch label.
+	ExtraLabels []string
+	// ExtraParams are rendered as a key/value list in the issue body, for
+	// example the roachtest cloud/cpu parameterization a failure occurred
+	// under.
+	ExtraParams map[string]string
+}
+
+// formatter renders the title and body of an issue (or update comment) for a
+// PostRequest. Unit tests and roachtest failures are formatted slightly
+// differently, hence the indirection.
+type formatter interface {
+	Title(req PostRequest) string
+	Body(ctx context.Context, p *poster, req PostRequest) (string, error)
+}
+
+type unitTestFormatter struct{}
+
+// Title implements formatter.
+func (unitTestFormatter) Title(req PostRequest) string {
+	return fmt.Sprintf("%s: %s failed", pkgShortName(req.PackageName), req.TestName)
+}
+
+// Body implements formatter.
+func (unitTestFormatter) Body(ctx context.Context, p *poster, req PostRequest) (string, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s.%s failed on branch=%s, %s:\n```\n%s\n```\n",
+		req.PackageName, req.TestName, p.Branch, renderSHA(p.SHA), strings.TrimSpace(req.Message))
+	if req.Artifacts != "" {
+		fmt.Fprintf(&buf, "\nArtifacts: %s\n", req.Artifacts)
+	}
+	if req.HelpCommand != nil {
+		if rendered := req.HelpCommand.Render(); rendered != "" {
+			fmt.Fprintf(&buf, "\nTo reproduce:\n\n%s\n", rendered)
+		}
+	}
+	for _, k := range sortedKeys(req.ExtraParams) {
+		fmt.Fprintf(&buf, "%s=%s\n", k, req.ExtraParams[k])
+	}
+	return buf.String(), nil
+}
+
+// UnitTestFormatter is the formatter used for ordinary `go test` failures.
+var UnitTestFormatter formatter = unitTestFormatter{}
+
+func pkgShortName(pkg string) string {
+	i := strings.LastIndex(pkg, "/")
+	if i < 0 {
+		return pkg
+	}
+	return pkg[i+1:]
+}
+
+// repoPath converts pkg, the full Go import path of a package under test
+// (e.g. "github.com/cockroachdb/cockroach/pkg/storage"), into the path
+// relative to the repo root (e.g. "pkg/storage") that IssueTracker.ListCommits
+// expects. Import paths that don't carry the "github.com/org/repo/" prefix
+// (e.g. already repo-relative, or a vendored dependency) are returned
+// unchanged.
+func repoPath(pkg, org, repo string) string {
+	prefix := "github.com/" + org + "/" + repo + "/"
+	if strings.HasPrefix(pkg, prefix) {
+		return pkg[len(prefix):]
+	}
+	return pkg
+}
+
+func renderSHA(sha string) string {
+	if len(sha) > 8 {
+		sha = sha[:8]
+	}
+	return "sha=" + sha
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	for i := 1; i < len(keys); i++ {
+		for j := i; j > 0 && keys[j-1] > keys[j]; j-- {
+			keys[j-1], keys[j] = keys[j], keys[j-1]
+		}
+	}
+	return keys
+}
+
+// poster drives the create-or-update decision for a single failure report
+// against whichever IssueTracker backend Options.Provider selects.
+type poster struct {
+	*Options
+	tracker IssueTracker
+	// blame picks an assignee from the failure's in-repo stack frames. Left
+	// nil in tests that don't care about assignment, in which case post
+	// skips straight to the IssueTracker.ListCommits fallback.
+	blame *blameAssigner
+}
+
+// Post constructs a poster from the environment and Options defaults, and
+// posts req using formatter f. It's the primary entry point used by CI.
+func Post(ctx context.Context, f formatter, req PostRequest) error {
+	opts := OptionsFromEnv()
+	tracker, err := NewIssueTracker(opts)
+	if err != nil {
+		return err
+	}
+	p := &poster{Options: opts, tracker: tracker}
+	// blameAssigner only understands GitHub's blame GraphQL API; for every
+	// other provider, chooseAssignee falls back to IssueTracker.ListCommits.
+	if opts.Provider == "" || opts.Provider == "github" {
+		p.blame = newBlameAssigner(opts.Token)
+	}
+	return p.post(ctx, f, req)
+}
+
+// OptionsFromEnv populates Options from the well-known CI environment
+// variables TeamCity sets for every build.
+func OptionsFromEnv() *Options {
+	provider := os.Getenv("ISSUE_TRACKER_PROVIDER")
+	return &Options{
+		Token:       os.Getenv(tokenEnvVar(provider)),
+		Org:         os.Getenv("GITHUB_ORG"),
+		Repo:        os.Getenv("GITHUB_REPO"),
+		SHA:         os.Getenv("BUILD_VCS_NUMBER"),
+		BuildTypeID: os.Getenv("TC_BUILD_TYPE_ID"),
+		BuildID:     os.Getenv("TC_BUILD_ID"),
+		ServerURL:   os.Getenv("TC_SERVER_URL"),
+		Branch:      os.Getenv("TC_BUILD_BRANCH"),
+		Tags:        os.Getenv("TAGS"),
+		Goflags:     os.Getenv("GOFLAGS"),
+		Provider:    provider,
+		Notifiers:   NotifiersFromEnv(),
+	}
+}
+
+// post is the shared create-or-update path: search for an existing issue
+// covering this failure, and either comment on it or file a new one. If no
+// exact match is found, a second, looser search is run to surface possibly
+// related issues (e.g. the same failure on a different branch) worth
+// mentioning in the new issue rather than silently duplicating.
+func (p *poster) post(ctx context.Context, f formatter, req PostRequest) error {
+	title := f.Title(req)
+	body, err := f.Body(ctx, p, req)
+	if err != nil {
+		return err
+	}
+
+	labels := append([]string{testFailureLabel, robotLabel, p.Branch}, req.ExtraLabels...)
+	fingerprint := Fingerprint(req.PackageName, req.TestName, req.Message)
+	normalizedMessage := normalizeMessage(req.Message)
+
+	existing, err := p.searchExact(ctx, title, labels, fingerprint, normalizedMessage)
+	if err != nil {
+		return err
+	}
+
+	var issueURL string
+	created := existing == nil
+	if existing == nil {
+		assignee, blameBlock, err := p.chooseAssignee(ctx, req)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "issues: blame assignment failed: %v\n", err)
+		} else {
+			if blameBlock != "" {
+				body += "\n\n" + blameBlock
+			}
+			if assignee != "" {
+				req.MentionOnCreate = append(req.MentionOnCreate, "@"+assignee)
+			}
+		}
+		related, err := p.searchRelated(ctx, title)
+		if err != nil {
+			return err
+		}
+		if related != nil {
+			body += fmt.Sprintf("\n\nPossibly related to #%d (%q), but that issue doesn't match the "+
+				"current branch so a new one is filed.", related.Number, related.Title)
+		}
+		body += "\n\n" + fingerprintComment(fingerprint)
+		issue, err := p.tracker.CreateIssue(ctx, p.Org, p.Repo, &IssueRequest{
+			Title:  title,
+			Body:   mentionBody(body, req.MentionOnCreate),
+			Labels: labels,
+		})
+		if err != nil {
+			return err
+		}
+		issueURL = issue.URL
+	} else {
+		comment, err := p.tracker.CreateComment(ctx, p.Org, p.Repo, existing.Number, &IssueComment{Body: body})
+		if err != nil {
+			return err
+		}
+		issueURL = comment.URL
+		if issueURL == "" {
+			issueURL = existing.URL
+		}
+	}
+
+	p.notify(ctx, req, issueURL, created)
+	return nil
+}
+
+// searchExact looks for an already-open issue covering this exact failure,
+// on the current branch. It first looks for the fingerprint marker this
+// package stamps into every issue body it creates (see fingerprint.go),
+// which survives message drift (line numbers, goroutine ids, timestamps)
+// that would otherwise defeat a plain title match. For issues filed before
+// fingerprinting existed, it falls back to a title match, using a
+// Levenshtein-similarity tiebreaker against the normalized message when the
+// title search turns up more than one open issue.
+func (p *poster) searchExact(
+	ctx context.Context, title string, labels []string, fingerprint, normalizedMessage string,
+) (*Issue, error) {
+	results, err := p.tracker.SearchIssues(ctx, SearchCriteria{
+		Owner:             p.Org,
+		Repo:              p.Repo,
+		Open:              true,
+		Labels:            labels,
+		FingerprintMarker: fingerprintComment(fingerprint),
+		TitleContains:     title,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(results) == 0 {
+		return nil, nil
+	}
+	// Issues carrying a fingerprint marker that doesn't match the current
+	// failure are a confirmed non-match, even though the backend's query
+	// (title/body substring search) surfaced them: exclude them from the
+	// title/Levenshtein fallback pool below, which is meant only for legacy
+	// issues filed before fingerprinting existed.
+	var legacy []Issue
+	for i := range results {
+		if fp, ok := parseFingerprintMarker(results[i].Body); ok {
+			if fp == fingerprint {
+				return &results[i], nil
+			}
+			continue
+		}
+		legacy = append(legacy, results[i])
+	}
+	if len(legacy) == 0 {
+		return nil, nil
+	}
+	if len(legacy) == 1 {
+		return &legacy[0], nil
+	}
+	best, bestScore := &legacy[0], similarity(normalizeMessage(legacy[0].Body), normalizedMessage)
+	for i := 1; i < len(legacy); i++ {
+		if s := similarity(normalizeMessage(legacy[i].Body), normalizedMessage); s > bestScore {
+			best, bestScore = &legacy[i], s
+		}
+	}
+	if bestScore > 0.85 {
+		return best, nil
+	}
+	return nil, nil
+}
+
+// searchRelated looks for an open issue with the same title regardless of
+// branch, so that a freshly filed issue can point at it instead of looking
+// like an unrelated failure.
+func (p *poster) searchRelated(ctx context.Context, title string) (*Issue, error) {
+	result, err := p.tracker.SearchIssues(ctx, SearchCriteria{
+		Owner:         p.Org,
+		Repo:          p.Repo,
+		Open:          true,
+		Labels:        []string{testFailureLabel, robotLabel},
+		TitleContains: title,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(result) == 0 {
+		return nil, nil
+	}
+	return &result[0], nil
+}
+
+func mentionBody(body string, mentions []string) string {
+	if len(mentions) == 0 {
+		return body
+	}
+	return strings.Join(mentions, " ") + "\n\n" + body
+}
@@ -13,7 +13,6 @@ package issues
 import (
 	"context"
 	"fmt"
-	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -23,11 +22,86 @@ import (
 	"github.com/cockroachdb/cockroach/pkg/testutils"
 	"github.com/cockroachdb/cockroach/pkg/testutils/skip"
 	"github.com/cockroachdb/datadriven"
-	"github.com/google/go-github/github"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+// fakeTracker is an in-memory IssueTracker used by TestPost. Driving the
+// test matrix through the IssueTracker interface (rather than mocking
+// go-github types directly) lets the very same scenarios run against every
+// backend this package supports; TestPost below only exercises the default
+// (GitHub-shaped) scenarios, but other _test.go files can reuse fakeTracker
+// verbatim for their own provider.
+type fakeTracker struct {
+	t   *testing.T
+	buf *strings.Builder
+
+	milestone   int
+	issueID     int
+	issueNumber int
+	assignee    string
+
+	matchingIssueNumber int
+	foundIssues         [][]Issue
+
+	createdIssue   *bool
+	createdComment *bool
+}
+
+func (f *fakeTracker) CreateIssue(_ context.Context, owner, repo string, issue *IssueRequest) (*Issue, error) {
+	*f.createdIssue = true
+	fmt.Fprintf(f.buf, "createIssue owner=%s repo=%s title=%s labels=%v:\n\n%s\n", owner, repo, issue.Title, issue.Labels, issue.Body)
+	return &Issue{Number: f.issueID, URL: fmt.Sprintf("fake://issue/%d", f.issueID)}, nil
+}
+
+func (f *fakeTracker) SearchIssues(_ context.Context, criteria SearchCriteria) ([]Issue, error) {
+	require.NotEmpty(f.t, f.foundIssues)
+	result := f.foundIssues[0]
+	f.foundIssues = f.foundIssues[1:]
+	fmt.Fprintf(f.buf, "searchIssue %+v: %v\n", criteria, result)
+	return result, nil
+}
+
+func (f *fakeTracker) CreateComment(_ context.Context, owner, repo string, number int, comment *IssueComment) (*IssueComment, error) {
+	assert.Equal(f.t, f.matchingIssueNumber, number)
+	*f.createdComment = true
+	fmt.Fprintf(f.buf, "createComment owner=%s repo=%s issue=%d:\n\n%s\n", owner, repo, number, comment.Body)
+	return &IssueComment{}, nil
+}
+
+func (f *fakeTracker) ListCommits(_ context.Context, owner, repo, path string) ([]Commit, error) {
+	fmt.Fprintf(f.buf, "listCommits owner=%s repo=%s path=%s\n", owner, repo, path)
+	return []Commit{{AuthorName: f.assignee}}, nil
+}
+
+func (f *fakeTracker) ListMilestones(_ context.Context, owner, repo string) ([]Milestone, error) {
+	result := []Milestone{
+		{Title: "3.3", Number: f.milestone},
+		{Title: "3.2", Number: 1},
+	}
+	fmt.Fprintf(f.buf, "listMilestones owner=%s repo=%s: result %v\n", owner, repo, result)
+	return result, nil
+}
+
+func (f *fakeTracker) ListClosedIssues(_ context.Context, owner, repo string, milestone int) ([]Issue, error) {
+	fmt.Fprintf(f.buf, "listClosedIssues owner=%s repo=%s milestone=%d\n", owner, repo, milestone)
+	return nil, nil
+}
+
+// fakeNotifier records the Event it was notified with so TestPost can
+// assert on it per scenario, instead of (or in addition to) on the tracker
+// calls.
+type fakeNotifier struct {
+	buf    *strings.Builder
+	events []Event
+}
+
+func (f *fakeNotifier) Notify(_ context.Context, event Event) error {
+	f.events = append(f.events, event)
+	fmt.Fprintf(f.buf, "notify created=%v issueURL=%s test=%s\n", event.Created, event.IssueURL, event.Request.TestName)
+	return nil
+}
+
 func TestPost(t *testing.T) {
 	const (
 		assignee    = "hodor" // fake GitHub handle we're returning as assignee
@@ -169,39 +243,29 @@ test logs left over in: /go/src/github.com/cockroachdb/cockroach/artifacts/logTe
 		foundOnlyRelatedIssue        = "related-issue"
 	)
 
-	matchingIssue := github.Issue{
-		Title:  github.String("boom"),
-		Number: github.Int(issueNumber),
-		Labels: []github.Label{{
-			Name: github.String("C-test-failure"),
-			URL:  github.String("fake"),
-		}, {
-			Name: github.String("O-robot"),
-			URL:  github.String("fake"),
-		}, {
-			Name: github.String("release-0.1"),
-			URL:  github.String("fake"),
-		}},
+	matchingIssue := Issue{
+		Title:  "boom",
+		Number: issueNumber,
+		Labels: []Label{
+			{Name: "C-test-failure", URL: "fake"},
+			{Name: "O-robot", URL: "fake"},
+			{Name: "release-0.1", URL: "fake"},
+		},
 	}
-	relatedIssue := github.Issue{
-		Title:  github.String("boom related"),
-		Number: github.Int(issueNumber + 1),
-		Labels: []github.Label{{
-			Name: github.String("C-test-failure"),
-			URL:  github.String("fake"),
-		}, {
-			Name: github.String("O-robot"),
-			URL:  github.String("fake"),
-		}, {
-			Name: github.String("release-0.2"), // here's the mismatch
-			URL:  github.String("fake"),
-		}},
+	relatedIssue := Issue{
+		Title:  "boom related",
+		Number: issueNumber + 1,
+		Labels: []Label{
+			{Name: "C-test-failure", URL: "fake"},
+			{Name: "O-robot", URL: "fake"},
+			{Name: "release-0.2", URL: "fake"}, // here's the mismatch
+		},
 	}
 
 	// This test determines from the file name what logic to run. The first
 	// subgroup determines the test case (from the above slice). The second
 	// determines whether matching/related issues exist.
-	foundIssueScenarios := map[string][][]github.Issue{
+	foundIssueScenarios := map[string][][]Issue{
 		foundNoIssue:                 {{}, {}},
 		foundOnlyMatchingIssue:       {{matchingIssue}, {}},
 		foundMatchingAndRelatedIssue: {{matchingIssue}, {relatedIssue}},
@@ -230,71 +294,27 @@ test logs left over in: /go/src/github.com/cockroachdb/cockroach/artifacts/logTe
 				return tag, nil
 			}
 
-			p := &poster{
-				Options: &opts,
-			}
-
 			createdIssue := false
-			p.createIssue = func(_ context.Context, owner string, repo string,
-				issue *github.IssueRequest) (*github.Issue, *github.Response, error) {
-				createdIssue = true
-				body := *issue.Body
-				issue.Body = nil
-				title := *issue.Title
-				issue.Title = nil
-
-				render := ghURL(t, title, body)
-				t.Log(render)
-				_, _ = fmt.Fprintf(&buf, "createIssue owner=%s repo=%s:\n%s\n\n%s\n\n%s\n\nRendered: %s", owner, repo, github.Stringify(issue), title, body, render)
-				return &github.Issue{ID: github.Int64(issueID)}, nil, nil
-			}
-
-			p.searchIssues = func(_ context.Context, query string,
-				opt *github.SearchOptions) (*github.IssuesSearchResult, *github.Response, error) {
-				result := &github.IssuesSearchResult{}
-
-				require.NotEmpty(t, results)
-				result.Issues, results = results[0], results[1:]
-
-				result.Total = github.Int(len(result.Issues))
-				_, _ = fmt.Fprintf(&buf, "searchIssue %s: %s\n", query, github.Stringify(&result.Issues))
-				return result, nil, nil
-			}
-
 			createdComment := false
-			p.createComment = func(
-				_ context.Context, owner string, repo string, number int, comment *github.IssueComment,
-			) (*github.IssueComment, *github.Response, error) {
-				assert.Equal(t, *matchingIssue.Number, number)
-				createdComment = true
-				render := ghURL(t, "<comment>", *comment.Body)
-				t.Log(render)
-				_, _ = fmt.Fprintf(&buf, "createComment owner=%s repo=%s issue=%d:\n\n%s\n\nRendered: %s", owner, repo, number, *comment.Body, render)
-				return &github.IssueComment{}, nil, nil
+			tracker := &fakeTracker{
+				t:                   t,
+				buf:                 &buf,
+				milestone:           milestone,
+				issueID:             issueID,
+				issueNumber:         issueNumber,
+				assignee:            assignee,
+				matchingIssueNumber: issueNumber,
+				foundIssues:         results,
+				createdIssue:        &createdIssue,
+				createdComment:      &createdComment,
 			}
 
-			p.listCommits = func(
-				_ context.Context, owner string, repo string, opts *github.CommitsListOptions,
-			) ([]*github.RepositoryCommit, *github.Response, error) {
-				_, _ = fmt.Fprintf(&buf, "listCommits owner=%s repo=%s %s\n", owner, repo, github.Stringify(opts))
-				assignee := assignee
-				return []*github.RepositoryCommit{
-					{
-						Author: &github.User{
-							Login: &assignee,
-						},
-					},
-				}, nil, nil
-			}
+			notifier := &fakeNotifier{buf: &buf}
+			opts.Notifiers = []Notifier{notifier}
 
-			p.listMilestones = func(_ context.Context, owner, repo string,
-				_ *github.MilestoneListOptions) ([]*github.Milestone, *github.Response, error) {
-				result := []*github.Milestone{
-					{Title: github.String("3.3"), Number: github.Int(milestone)},
-					{Title: github.String("3.2"), Number: github.Int(1)},
-				}
-				_, _ = fmt.Fprintf(&buf, "listMilestones owner=%s repo=%s: result %s\n", owner, repo, github.Stringify(result))
-				return result, nil, nil
+			p := &poster{
+				Options: &opts,
+				tracker: tracker,
 			}
 
 			repro := UnitTestHelpCommand(c.reproCmd)
@@ -324,11 +344,99 @@ test logs left over in: /go/src/github.com/cockroachdb/cockroach/artifacts/logTe
 				t.Errorf("unhandled: %s", foundIssue)
 			}
 
+			require.Len(t, notifier.events, 1)
+			require.Equal(t, createdIssue, notifier.events[0].Created)
+
 			return buf.String()
 		})
 	})
 }
 
+// TestSearchExactExcludesMismatchedFingerprint covers the fingerprint
+// fallback bug: a loose title/body search can surface an issue that already
+// carries a *different* fingerprint marker (i.e. it's confirmed to track an
+// unrelated failure). That issue must not be eligible for the
+// title/Levenshtein fallback used for legacy, marker-less issues.
+func TestSearchExactExcludesMismatchedFingerprint(t *testing.T) {
+	var buf strings.Builder
+	tracker := &fakeTracker{
+		t:   t,
+		buf: &buf,
+		foundIssues: [][]Issue{
+			{{Number: 30, Title: "boom", Body: fingerprintComment("def456def456")}},
+		},
+	}
+	p := &poster{Options: &Options{Org: "cockroachdb", Repo: "cockroach"}, tracker: tracker}
+
+	issue, err := p.searchExact(context.Background(), "boom", nil, "abc123abc123", "normalized message")
+	require.NoError(t, err)
+	require.Nil(t, issue)
+}
+
+// TestSearchExactMatchesAcrossMessageDrift proves the fingerprint produced
+// for the same failure is stable across two textually different renderings
+// of the same panic (different heap addresses, goroutine ids and
+// timestamps), and that searchExact matches the existing issue for both.
+func TestSearchExactMatchesAcrossMessageDrift(t *testing.T) {
+	const pkg, test = "github.com/cockroachdb/cockroach/pkg/storage", "TestFlakyPanic"
+	messageA := "panic: boom at 0xdeadbeef\n" +
+		"180517 07:33:43.763059 69575 pkg/storage/replica.go:42 +0x12\n" +
+		"goroutine 7 [running]:\n"
+	messageB := "panic: boom at 0xfeedface\n" +
+		"210923 11:02:09.001122 41 pkg/storage/replica.go:42 +0x99\n" +
+		"goroutine 412 [running]:\n"
+
+	fpA := Fingerprint(pkg, test, messageA)
+	fpB := Fingerprint(pkg, test, messageB)
+	require.Equal(t, fpA, fpB)
+
+	var buf strings.Builder
+	tracker := &fakeTracker{
+		t:   t,
+		buf: &buf,
+		foundIssues: [][]Issue{
+			{{Number: 30, Title: "storage: TestFlakyPanic failed", Body: fingerprintComment(fpA)}},
+		},
+	}
+	p := &poster{Options: &Options{Org: "cockroachdb", Repo: "cockroach"}, tracker: tracker}
+	issue, err := p.searchExact(context.Background(), "storage: TestFlakyPanic failed", nil, fpB, normalizeMessage(messageB))
+	require.NoError(t, err)
+	require.Equal(t, 30, issue.Number)
+}
+
+// TestOptionsFromEnvSelectsTokenByProvider guards against picking a token
+// belonging to a different backend than the one ISSUE_TRACKER_PROVIDER
+// selects, when a CI environment happens to carry every backend's token at
+// once (e.g. a mirror job building against several forges).
+func TestOptionsFromEnvSelectsTokenByProvider(t *testing.T) {
+	env := map[string]string{
+		"GITHUB_API_TOKEN":       "github-tok",
+		"GITLAB_API_TOKEN":       "gitlab-tok",
+		"GITEA_API_TOKEN":        "gitea-tok",
+		"BITBUCKET_API_TOKEN":    "bitbucket-tok",
+		"AZURE_DEVOPS_API_TOKEN": "azuredevops-tok",
+	}
+	unset := setEnv(env)
+	defer unset()
+
+	testCases := []struct {
+		provider string
+		want     string
+	}{
+		{"", "github-tok"},
+		{"github", "github-tok"},
+		{"gitlab", "gitlab-tok"},
+		{"gitea", "gitea-tok"},
+		{"bitbucket", "bitbucket-tok"},
+		{"azuredevops", "azuredevops-tok"},
+	}
+	for _, c := range testCases {
+		unsetProvider := setEnv(map[string]string{"ISSUE_TRACKER_PROVIDER": c.provider})
+		require.Equal(t, c.want, OptionsFromEnv().Token)
+		unsetProvider()
+	}
+}
+
 func TestPostEndToEnd(t *testing.T) {
 	skip.IgnoreLint(t, "only for manual testing")
 
@@ -399,13 +507,3 @@ func setEnv(kv map[string]string) func() {
 		}
 	}
 }
-
-func ghURL(t *testing.T, title, body string) string {
-	u, err := url.Parse("https://github.com/cockroachdb/cockroach/issues/new")
-	require.NoError(t, err)
-	q := u.Query()
-	q.Add("title", title)
-	q.Add("body", body)
-	u.RawQuery = q.Encode()
-	return u.String()
-}
@@ -0,0 +1,222 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package issues
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"os"
+	"time"
+)
+
+// Event describes the outcome of a single poster.post call, for delivery to
+// Notifiers. It carries the same PostRequest that was filed, plus what
+// happened to the issue tracker as a result.
+type Event struct {
+	Request PostRequest
+	// IssueURL is the URL of the issue that was created or commented on.
+	IssueURL string
+	// Created is true if a new issue was filed, false if an existing one was
+	// updated with a comment.
+	Created bool
+}
+
+// Notifier is a sink that gets told about the outcome of a post, in
+// addition to the issue tracker itself. Implementations must not block
+// indefinitely and should retry transient failures internally; a Notify
+// error is logged but never fails the post that triggered it.
+type Notifier interface {
+	Notify(ctx context.Context, event Event) error
+}
+
+const notifyRetries = 3
+
+// notifyRetry calls fn up to notifyRetries times, backing off linearly
+// between attempts, and returns the last error if all attempts fail.
+func notifyRetry(ctx context.Context, fn func() error) error {
+	var err error
+	for attempt := 0; attempt < notifyRetries; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if attempt == notifyRetries-1 {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt+1) * 200 * time.Millisecond):
+		}
+	}
+	return err
+}
+
+// notify dispatches event to every configured Notifier. Failures are
+// swallowed (beyond being surfaced to stderr) since a broken notification
+// sink must never fail the underlying issue post.
+func (p *poster) notify(ctx context.Context, req PostRequest, issueURL string, created bool) {
+	if len(p.Notifiers) == 0 {
+		return
+	}
+	event := Event{Request: req, IssueURL: issueURL, Created: created}
+	for _, n := range p.Notifiers {
+		if err := n.Notify(ctx, event); err != nil {
+			fmt.Fprintf(os.Stderr, "issues: notifier failed: %v\n", err)
+		}
+	}
+}
+
+// NotifiersFromEnv builds the list of Notifiers implied by the environment
+// variables TeamCity jobs may set. Any subset (including none) may be
+// configured.
+func NotifiersFromEnv() []Notifier {
+	var notifiers []Notifier
+	if url := os.Getenv("NOTIFY_WEBHOOK_URL"); url != "" {
+		notifiers = append(notifiers, &webhookNotifier{url: url, do: http.DefaultClient.Do})
+	}
+	if url := os.Getenv("NOTIFY_SLACK_WEBHOOK"); url != "" {
+		notifiers = append(notifiers, &slackNotifier{url: url, do: http.DefaultClient.Do})
+	}
+	if key := os.Getenv("NOTIFY_PAGERDUTY_ROUTING_KEY"); key != "" {
+		notifiers = append(notifiers, &pagerDutyNotifier{routingKey: key, do: http.DefaultClient.Do})
+	}
+	if addr := os.Getenv("NOTIFY_SMTP_ADDR"); addr != "" {
+		notifiers = append(notifiers, &emailNotifier{
+			addr: addr,
+			from: os.Getenv("NOTIFY_SMTP_FROM"),
+			to:   os.Getenv("NOTIFY_SMTP_TO"),
+		})
+	}
+	return notifiers
+}
+
+// postJSON POSTs body as JSON to url using do, returning an error for any
+// non-2xx response.
+func postJSON(ctx context.Context, do func(*http.Request) (*http.Response, error), url string, body interface{}) error {
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("notify %s: status %d", url, resp.StatusCode)
+	}
+	return nil
+}
+
+// webhookNotifier POSTs a generic JSON payload describing the event to an
+// arbitrary outgoing webhook.
+type webhookNotifier struct {
+	url string
+	do  func(*http.Request) (*http.Response, error)
+}
+
+// Notify implements Notifier.
+func (w *webhookNotifier) Notify(ctx context.Context, event Event) error {
+	return notifyRetry(ctx, func() error {
+		return postJSON(ctx, w.do, w.url, event)
+	})
+}
+
+// slackNotifier posts a short summary to a Slack incoming-webhook URL.
+type slackNotifier struct {
+	url string
+	do  func(*http.Request) (*http.Response, error)
+}
+
+// Notify implements Notifier.
+func (s *slackNotifier) Notify(ctx context.Context, event Event) error {
+	verb := "updated"
+	if event.Created {
+		verb = "filed"
+	}
+	text := fmt.Sprintf("Issue %s for %s.%s: %s", verb, event.Request.PackageName, event.Request.TestName, event.IssueURL)
+	return notifyRetry(ctx, func() error {
+		return postJSON(ctx, s.do, s.url, map[string]string{"text": text})
+	})
+}
+
+// releaseBlockerLabel is the label that escalates a test-failure post to a
+// PagerDuty page, rather than just a Slack/webhook notification.
+const releaseBlockerLabel = "release-blocker"
+
+// pagerDutyNotifier triggers a PagerDuty Events v2 incident for failures
+// carrying the release-blocker label; other failures are ignored.
+type pagerDutyNotifier struct {
+	routingKey string
+	do         func(*http.Request) (*http.Response, error)
+}
+
+// Notify implements Notifier.
+func (pd *pagerDutyNotifier) Notify(ctx context.Context, event Event) error {
+	if !hasLabel(event.Request.ExtraLabels, releaseBlockerLabel) {
+		return nil
+	}
+	payload := map[string]interface{}{
+		"routing_key":  pd.routingKey,
+		"event_action": "trigger",
+		"payload": map[string]interface{}{
+			"summary":  fmt.Sprintf("release-blocker: %s.%s failed", event.Request.PackageName, event.Request.TestName),
+			"source":   event.Request.PackageName,
+			"severity": "error",
+		},
+		"links": []map[string]string{{"href": event.IssueURL, "text": "Tracking issue"}},
+	}
+	return notifyRetry(ctx, func() error {
+		return postJSON(ctx, pd.do, "https://events.pagerduty.com/v2/enqueue", payload)
+	})
+}
+
+func hasLabel(labels []string, want string) bool {
+	for _, l := range labels {
+		if l == want {
+			return true
+		}
+	}
+	return false
+}
+
+// emailNotifier sends a plain-text summary over SMTP.
+type emailNotifier struct {
+	addr       string
+	from, to   string
+	sendMailFn func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+// Notify implements Notifier.
+func (e *emailNotifier) Notify(ctx context.Context, event Event) error {
+	verb := "updated"
+	if event.Created {
+		verb = "filed"
+	}
+	subject := fmt.Sprintf("Subject: [%s] issue %s for %s\r\n\r\n", e.addr, verb, event.Request.TestName)
+	body := subject + fmt.Sprintf("%s.%s: %s\n", event.Request.PackageName, event.Request.TestName, event.IssueURL)
+	send := e.sendMailFn
+	if send == nil {
+		send = smtp.SendMail
+	}
+	return notifyRetry(ctx, func() error {
+		return send(e.addr, nil, e.from, []string{e.to}, []byte(body))
+	})
+}
@@ -0,0 +1,176 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package issues
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// releaseNotesEntry groups every occurrence of the same flaky-test
+// fingerprint into a single bullet.
+type releaseNotesEntry struct {
+	PackageName   string
+	TestName      string
+	IssueURL      string
+	ResolutionRef string
+	Count         int
+}
+
+// defaultReleaseNotesTemplate renders one bullet per releaseNotesEntry; it's
+// deliberately plain so release managers can paste the output straight into
+// a release-notes document.
+const defaultReleaseNotesTemplate = `{{range .}}- {{.PackageName}}: {{.TestName}} ({{.Count}} occurrence{{if ne .Count 1}}s{{end}}), fixed in [{{.IssueURL}}]({{.IssueURL}}){{if .ResolutionRef}} by {{.ResolutionRef}}{{end}}
+{{end}}`
+
+// ReleaseNotes walks closed issues in the milestone named version (as
+// returned by ListMilestones), keeps the ones carrying both
+// testFailureLabel and robotLabel, groups them by fingerprint (falling back
+// to package+test for issues filed before fingerprinting existed) and
+// renders tmplText (a text/template, defaulting to
+// defaultReleaseNotesTemplate) over the resulting entries.
+func ReleaseNotes(
+	ctx context.Context, tracker IssueTracker, org, repo, version, tmplText string,
+) (string, error) {
+	milestones, err := tracker.ListMilestones(ctx, org, repo)
+	if err != nil {
+		return "", fmt.Errorf("listing milestones: %w", err)
+	}
+	var milestoneNumber int
+	found := false
+	for _, m := range milestones {
+		if m.Title == version {
+			milestoneNumber = m.Number
+			found = true
+			break
+		}
+	}
+	if !found {
+		return "", fmt.Errorf("no milestone named %q", version)
+	}
+
+	closed, err := tracker.ListClosedIssues(ctx, org, repo, milestoneNumber)
+	if err != nil {
+		return "", fmt.Errorf("listing closed issues: %w", err)
+	}
+
+	byKey := map[string]*releaseNotesEntry{}
+	var order []string
+	for _, issue := range closed {
+		if !hasLabel(labelNames(issue.Labels), testFailureLabel) || !hasLabel(labelNames(issue.Labels), robotLabel) {
+			continue
+		}
+		key, ok := parseFingerprintMarker(issue.Body)
+		if !ok {
+			key = issue.Title
+		}
+		entry, exists := byKey[key]
+		if !exists {
+			entry = &releaseNotesEntry{
+				IssueURL:      issue.URL,
+				ResolutionRef: issue.ClosedByRef,
+			}
+			entry.PackageName, entry.TestName = splitTitle(issue.Title)
+			byKey[key] = entry
+			order = append(order, key)
+		}
+		entry.Count++
+	}
+
+	entries := make([]*releaseNotesEntry, len(order))
+	for i, k := range order {
+		entries[i] = byKey[k]
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].PackageName != entries[j].PackageName {
+			return entries[i].PackageName < entries[j].PackageName
+		}
+		return entries[i].TestName < entries[j].TestName
+	})
+
+	if tmplText == "" {
+		tmplText = defaultReleaseNotesTemplate
+	}
+	tmpl, err := template.New("release-notes").Parse(tmplText)
+	if err != nil {
+		return "", fmt.Errorf("parsing template: %w", err)
+	}
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, entries); err != nil {
+		return "", fmt.Errorf("rendering template: %w", err)
+	}
+	return buf.String(), nil
+}
+
+func labelNames(labels []Label) []string {
+	names := make([]string, len(labels))
+	for i, l := range labels {
+		names[i] = l.Name
+	}
+	return names
+}
+
+// splitTitle recovers "package: test failed" formatted titles (see
+// unitTestFormatter.Title) back into their package/test parts, stripping the
+// trailing " failed" unitTestFormatter.Title always appends; titles that
+// don't match the convention are returned verbatim as the test name.
+func splitTitle(title string) (pkg, test string) {
+	i := strings.Index(title, ": ")
+	if i < 0 {
+		return "", title
+	}
+	return title[:i], strings.TrimSuffix(title[i+2:], " failed")
+}
+
+// RunReleaseNotes is the entry point for the `release-notes` subcommand:
+// `github-post release-notes --org=... --repo=... --milestone=vX.Y
+// [--template=path] [--provider=gitlab|...]`. It prints the rendered
+// Markdown to stdout.
+func RunReleaseNotes(args []string) error {
+	fs := flag.NewFlagSet("release-notes", flag.ContinueOnError)
+	org := fs.String("org", "", "org/owner the milestone belongs to")
+	repo := fs.String("repo", "", "repo the milestone belongs to")
+	milestone := fs.String("milestone", "", "milestone/version to summarize, e.g. v23.1.0")
+	provider := fs.String("provider", "", "issue tracker provider (default github)")
+	templatePath := fs.String("template", "", "path to a text/template overriding the default bullet format")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *org == "" || *repo == "" || *milestone == "" {
+		return fmt.Errorf("--org, --repo and --milestone are required")
+	}
+
+	var tmplText string
+	if *templatePath != "" {
+		buf, err := os.ReadFile(*templatePath)
+		if err != nil {
+			return fmt.Errorf("reading --template: %w", err)
+		}
+		tmplText = string(buf)
+	}
+
+	tracker, err := NewIssueTracker(&Options{Provider: *provider, Token: os.Getenv(tokenEnvVar(*provider))})
+	if err != nil {
+		return err
+	}
+	notes, err := ReleaseNotes(context.Background(), tracker, *org, *repo, *milestone, tmplText)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(os.Stdout, notes)
+	return err
+}
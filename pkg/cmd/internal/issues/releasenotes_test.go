@@ -0,0 +1,106 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package issues
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitTitle(t *testing.T) {
+	pkg, test := splitTitle("storage: TestFoo failed")
+	require.Equal(t, "storage", pkg)
+	require.Equal(t, "TestFoo", test)
+
+	// Titles that don't match the "pkg: test failed" convention are returned
+	// verbatim as the test name.
+	pkg, test = splitTitle("some manually filed issue")
+	require.Equal(t, "", pkg)
+	require.Equal(t, "some manually filed issue", test)
+}
+
+// releaseNotesTracker is a minimal IssueTracker stub for ReleaseNotes,
+// which only calls ListMilestones and ListClosedIssues.
+type releaseNotesTracker struct {
+	milestones []Milestone
+	closed     []Issue
+}
+
+func (r *releaseNotesTracker) CreateIssue(context.Context, string, string, *IssueRequest) (*Issue, error) {
+	panic("unused")
+}
+func (r *releaseNotesTracker) SearchIssues(context.Context, SearchCriteria) ([]Issue, error) {
+	panic("unused")
+}
+func (r *releaseNotesTracker) CreateComment(context.Context, string, string, int, *IssueComment) (*IssueComment, error) {
+	panic("unused")
+}
+func (r *releaseNotesTracker) ListCommits(context.Context, string, string, string) ([]Commit, error) {
+	panic("unused")
+}
+func (r *releaseNotesTracker) ListMilestones(context.Context, string, string) ([]Milestone, error) {
+	return r.milestones, nil
+}
+func (r *releaseNotesTracker) ListClosedIssues(_ context.Context, _, _ string, milestone int) ([]Issue, error) {
+	var out []Issue
+	for _, issue := range r.closed {
+		out = append(out, issue)
+	}
+	_ = milestone
+	return out, nil
+}
+
+func TestReleaseNotesGroupsByFingerprintAndRendersPackageTest(t *testing.T) {
+	tracker := &releaseNotesTracker{
+		milestones: []Milestone{{Number: 7, Title: "v23.1.0"}},
+		closed: []Issue{
+			{
+				Title: "storage: TestFoo failed",
+				URL:   "fake://issue/1",
+				Body:  fingerprintComment("abc123abc123"),
+				Labels: []Label{
+					{Name: testFailureLabel}, {Name: robotLabel},
+				},
+				ClosedByRef: "deadbeef",
+			},
+			{
+				// Same fingerprint, a second occurrence: must be counted,
+				// not rendered as a second bullet.
+				Title: "storage: TestFoo failed",
+				URL:   "fake://issue/1",
+				Body:  fingerprintComment("abc123abc123"),
+				Labels: []Label{
+					{Name: testFailureLabel}, {Name: robotLabel},
+				},
+			},
+			{
+				// Missing robotLabel: not a CI-filed flake, excluded.
+				Title:  "sql: TestBar failed",
+				URL:    "fake://issue/2",
+				Labels: []Label{{Name: testFailureLabel}},
+			},
+		},
+	}
+
+	notes, err := ReleaseNotes(context.Background(), tracker, "cockroachdb", "cockroach", "v23.1.0", "")
+	require.NoError(t, err)
+	require.Contains(t, notes, "storage: TestFoo (2 occurrences), fixed in [fake://issue/1](fake://issue/1) by deadbeef")
+	require.NotContains(t, notes, "TestFoo failed")
+	require.NotContains(t, notes, "TestBar")
+}
+
+func TestReleaseNotesUnknownMilestone(t *testing.T) {
+	tracker := &releaseNotesTracker{milestones: []Milestone{{Number: 1, Title: "v1.0.0"}}}
+	_, err := ReleaseNotes(context.Background(), tracker, "cockroachdb", "cockroach", "v99.0.0", "")
+	require.NotNil(t, err)
+}
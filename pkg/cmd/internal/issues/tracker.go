@@ -0,0 +1,154 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package issues
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// Label is a single label attached to an issue.
+type Label struct {
+	Name string
+	URL  string
+}
+
+// Issue is the backend-agnostic view of an issue (or merge/pull request,
+// for the commit-lookup use cases) that this package needs.
+type Issue struct {
+	Number int
+	Title  string
+	Body   string
+	URL    string
+	Labels []Label
+	// ClosedByRef identifies the commit or pull request that closed this
+	// issue, when the backend can cheaply determine it (GitHub's
+	// IssuesEvents "closed" event carries a commit SHA). Left empty when
+	// unavailable; see ReleaseNotes, which uses it as a best-effort link.
+	ClosedByRef string
+}
+
+// IssueComment is a comment posted on an existing Issue.
+type IssueComment struct {
+	Body string
+	URL  string
+}
+
+// IssueRequest is the payload used to create a new Issue.
+type IssueRequest struct {
+	Title  string
+	Body   string
+	Labels []string
+}
+
+// Milestone is a tracker milestone/iteration, used to bound the window a
+// release-notes query runs over.
+type Milestone struct {
+	Number int
+	Title  string
+}
+
+// Commit is the subset of commit metadata this package needs in order to
+// attribute a failure to an author.
+type Commit struct {
+	SHA        string
+	AuthorName string
+}
+
+// SearchCriteria is a backend-agnostic description of the issue search
+// poster needs. Each IssueTracker implementation translates it into its own
+// query syntax; poster never builds a query string itself, since GitHub's
+// `in:body`/`in:title`/`label:` search-operator syntax means nothing to
+// GitLab, Gitea, Bitbucket or Azure DevOps.
+type SearchCriteria struct {
+	Owner, Repo string
+	// Open restricts the search to open issues.
+	Open bool
+	// Labels restricts the search to issues carrying all of these labels.
+	// The Bitbucket and Azure DevOps trackers do not filter by label.
+	Labels []string
+	// FingerprintMarker, if set, matches issues whose body contains this
+	// exact HTML-comment marker (see fingerprint.go).
+	FingerprintMarker string
+	// TitleContains, if set, matches issues whose title contains this exact
+	// substring. When FingerprintMarker is set too, the GitHub tracker
+	// matches issues satisfying either one; the GitLab, Gitea, Bitbucket and
+	// Azure DevOps trackers search for FingerprintMarker only and ignore
+	// TitleContains.
+	TitleContains string
+}
+
+// IssueTracker is the six-operation surface this package needs from a
+// forge. GitHub is the original (and default) implementation; see
+// tracker_gitlab.go, tracker_gitea.go, tracker_bitbucket.go and
+// tracker_azuredevops.go for the others. Keeping this interface narrow is
+// what lets a single poster implementation serve every backend.
+type IssueTracker interface {
+	// CreateIssue files a new issue.
+	CreateIssue(ctx context.Context, owner, repo string, issue *IssueRequest) (*Issue, error)
+	// SearchIssues returns issues matching criteria, translated into
+	// whatever query syntax the backend speaks.
+	SearchIssues(ctx context.Context, criteria SearchCriteria) ([]Issue, error)
+	// CreateComment adds a comment to an existing issue.
+	CreateComment(ctx context.Context, owner, repo string, number int, comment *IssueComment) (*IssueComment, error)
+	// ListCommits lists recent commits touching a path, most recent first.
+	ListCommits(ctx context.Context, owner, repo, path string) ([]Commit, error)
+	// ListMilestones lists the milestones/iterations for a repo. The GitHub
+	// implementation includes closed milestones; the others return what the
+	// backend's milestone listing returns by default.
+	ListMilestones(ctx context.Context, owner, repo string) ([]Milestone, error)
+	// ListClosedIssues lists closed issues within a milestone, for
+	// ReleaseNotes to summarize. Implementations populate Issue.ClosedByRef
+	// on a best-effort basis.
+	ListClosedIssues(ctx context.Context, owner, repo string, milestone int) ([]Issue, error)
+}
+
+// NewIssueTracker constructs the IssueTracker implied by opts.Provider,
+// authenticating with opts.Token (see tokenEnvVar for where callers read it
+// from). The non-GitHub backends read their base URL from the environment
+// (GITLAB_BASE_URL, GITEA_BASE_URL, BITBUCKET_BASE_URL,
+// AZURE_DEVOPS_ORG_URL).
+func NewIssueTracker(opts *Options) (IssueTracker, error) {
+	switch opts.Provider {
+	case "", "github":
+		return newGitHubTracker(opts.Token), nil
+	case "gitlab":
+		return newGitLabTracker(opts.Token, os.Getenv("GITLAB_BASE_URL")), nil
+	case "gitea":
+		return newGiteaTracker(opts.Token, os.Getenv("GITEA_BASE_URL")), nil
+	case "bitbucket":
+		return newBitbucketTracker(opts.Token, os.Getenv("BITBUCKET_BASE_URL")), nil
+	case "azuredevops":
+		return newAzureDevOpsTracker(opts.Token, os.Getenv("AZURE_DEVOPS_ORG_URL")), nil
+	default:
+		return nil, fmt.Errorf("unknown issue tracker provider %q", opts.Provider)
+	}
+}
+
+// tokenEnvVar returns the name of the environment variable holding
+// provider's API token, so callers building Options from the environment
+// read the one token that's actually relevant to the selected backend
+// instead of guessing across every backend's env var.
+func tokenEnvVar(provider string) string {
+	switch provider {
+	case "gitlab":
+		return "GITLAB_API_TOKEN"
+	case "gitea":
+		return "GITEA_API_TOKEN"
+	case "bitbucket":
+		return "BITBUCKET_API_TOKEN"
+	case "azuredevops":
+		return "AZURE_DEVOPS_API_TOKEN"
+	default:
+		return "GITHUB_API_TOKEN"
+	}
+}
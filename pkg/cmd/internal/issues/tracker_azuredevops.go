@@ -0,0 +1,247 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package issues
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// azureDevOpsTracker implements IssueTracker against Azure DevOps Work Item
+// Tracking, representing each failure as a "Bug" work item. orgURL is the
+// organization URL, e.g. "https://dev.azure.com/my-org".
+type azureDevOpsTracker struct {
+	token string
+	org   string
+	do    func(*http.Request) (*http.Response, error)
+}
+
+func newAzureDevOpsTracker(token, orgURL string) *azureDevOpsTracker {
+	return &azureDevOpsTracker{token: token, org: orgURL, do: http.DefaultClient.Do}
+}
+
+func (t *azureDevOpsTracker) authHeader() string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(":"+t.token))
+}
+
+func (t *azureDevOpsTracker) request(
+	ctx context.Context, method, path, contentType string, body []byte, out interface{},
+) error {
+	req, err := http.NewRequestWithContext(ctx, method, t.org+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", t.authHeader())
+	req.Header.Set("Content-Type", contentType)
+	resp, err := t.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("azuredevops: %s %s: status %d", method, path, resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+type azureWorkItem struct {
+	ID     int `json:"id"`
+	Fields struct {
+		Title string `json:"System.Title"`
+		Descr string `json:"System.Description"`
+	} `json:"fields"`
+	URL string `json:"url"`
+}
+
+type jsonPatchOp struct {
+	Op    string `json:"op"`
+	Path  string `json:"path"`
+	Value string `json:"value"`
+}
+
+// CreateIssue implements IssueTracker, filing a Bug work item. repo becomes
+// the Azure DevOps "project" (owner/org is already fixed by t.org).
+func (t *azureDevOpsTracker) CreateIssue(
+	ctx context.Context, owner, repo string, issue *IssueRequest,
+) (*Issue, error) {
+	ops := []jsonPatchOp{
+		{Op: "add", Path: "/fields/System.Title", Value: issue.Title},
+		{Op: "add", Path: "/fields/System.Description", Value: issue.Body},
+	}
+	for _, l := range issue.Labels {
+		ops = append(ops, jsonPatchOp{Op: "add", Path: "/fields/System.Tags", Value: l})
+	}
+	buf, err := json.Marshal(ops)
+	if err != nil {
+		return nil, err
+	}
+	path := fmt.Sprintf("/%s/_apis/wit/workitems/$Bug?api-version=6.0", url.PathEscape(repo))
+	var out azureWorkItem
+	if err := t.request(ctx, http.MethodPost, path, "application/json-patch+json", buf, &out); err != nil {
+		return nil, err
+	}
+	return fromAzureWorkItem(&out), nil
+}
+
+// SearchIssues implements IssueTracker using the WIQL query language, scoped
+// to criteria.Repo's project. WIQL string literals are single-quoted, with
+// embedded single quotes doubled (there's no Go-style backslash escaping).
+func (t *azureDevOpsTracker) SearchIssues(ctx context.Context, criteria SearchCriteria) ([]Issue, error) {
+	search := criteria.FingerprintMarker
+	if search == "" {
+		search = criteria.TitleContains
+	}
+	wiqlQuery := fmt.Sprintf(
+		"SELECT [System.Id] FROM WorkItems WHERE [System.WorkItemType] = 'Bug' AND [System.Title] CONTAINS '%s'",
+		wiqlQuote(search))
+	if criteria.Open {
+		wiqlQuery += " AND [System.State] <> 'Closed'"
+	}
+	wiql := map[string]string{"query": wiqlQuery}
+	buf, err := json.Marshal(wiql)
+	if err != nil {
+		return nil, err
+	}
+	var refs struct {
+		WorkItems []struct {
+			ID int `json:"id"`
+		} `json:"workItems"`
+	}
+	path := fmt.Sprintf("/%s/_apis/wit/wiql?api-version=6.0", url.PathEscape(criteria.Repo))
+	if err := t.request(ctx, http.MethodPost, path, "application/json", buf, &refs); err != nil {
+		return nil, err
+	}
+	issues := make([]Issue, 0, len(refs.WorkItems))
+	for _, ref := range refs.WorkItems {
+		var out azureWorkItem
+		itemPath := fmt.Sprintf("/%s/_apis/wit/workitems/%d?api-version=6.0", url.PathEscape(criteria.Repo), ref.ID)
+		if err := t.request(ctx, http.MethodGet, itemPath, "application/json", nil, &out); err != nil {
+			return nil, err
+		}
+		issues = append(issues, *fromAzureWorkItem(&out))
+	}
+	return issues, nil
+}
+
+// wiqlQuote escapes a string for embedding inside a single-quoted WIQL
+// string literal.
+func wiqlQuote(s string) string {
+	return strings.ReplaceAll(s, "'", "''")
+}
+
+// CreateComment implements IssueTracker, adding a work item discussion
+// comment.
+func (t *azureDevOpsTracker) CreateComment(
+	ctx context.Context, owner, repo string, number int, comment *IssueComment,
+) (*IssueComment, error) {
+	body := map[string]string{"text": comment.Body}
+	buf, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	path := fmt.Sprintf("/%s/_apis/wit/workItems/%d/comments?api-version=6.0-preview.3", url.PathEscape(repo), number)
+	if err := t.request(ctx, http.MethodPost, path, "application/json", buf, nil); err != nil {
+		return nil, err
+	}
+	return &IssueComment{Body: comment.Body}, nil
+}
+
+// ListCommits implements IssueTracker against the Azure Repos Git API.
+func (t *azureDevOpsTracker) ListCommits(ctx context.Context, owner, repo, path string) ([]Commit, error) {
+	reqPath := fmt.Sprintf("/%s/_apis/git/repositories/%s/commits?searchCriteria.itemPath=%s&api-version=6.0",
+		url.PathEscape(owner), url.PathEscape(repo), url.QueryEscape(path))
+	var out struct {
+		Value []struct {
+			CommitID string `json:"commitId"`
+			Author   struct {
+				Name string `json:"name"`
+			} `json:"author"`
+		} `json:"value"`
+	}
+	if err := t.request(ctx, http.MethodGet, reqPath, "application/json", nil, &out); err != nil {
+		return nil, err
+	}
+	commits := make([]Commit, len(out.Value))
+	for i, c := range out.Value {
+		commits[i] = Commit{SHA: c.CommitID, AuthorName: c.Author.Name}
+	}
+	return commits, nil
+}
+
+// ListMilestones implements IssueTracker, mapping Azure DevOps iterations
+// onto the Milestone model.
+func (t *azureDevOpsTracker) ListMilestones(ctx context.Context, owner, repo string) ([]Milestone, error) {
+	path := fmt.Sprintf("/%s/_apis/work/teamsettings/iterations?api-version=6.0", url.PathEscape(repo))
+	var out struct {
+		Value []struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+		} `json:"value"`
+	}
+	if err := t.request(ctx, http.MethodGet, path, "application/json", nil, &out); err != nil {
+		return nil, err
+	}
+	milestones := make([]Milestone, len(out.Value))
+	for i, m := range out.Value {
+		milestones[i] = Milestone{Title: m.Name}
+	}
+	return milestones, nil
+}
+
+// ListClosedIssues implements IssueTracker via a WIQL query for closed Bug
+// work items. The query does not filter by iteration: milestone is unused
+// (ListMilestones leaves Milestone.Number zero, since Azure DevOps
+// iterations are identified by GUID), so every closed Bug work item is
+// returned. ClosedByRef is left empty; Azure
+// DevOps work items don't carry a commit reference the way GitHub's
+// timeline events do.
+func (t *azureDevOpsTracker) ListClosedIssues(
+	ctx context.Context, owner, repo string, milestone int,
+) ([]Issue, error) {
+	wiql := map[string]string{
+		"query": "SELECT [System.Id] FROM WorkItems WHERE [System.WorkItemType] = 'Bug' AND [System.State] = 'Closed'",
+	}
+	buf, err := json.Marshal(wiql)
+	if err != nil {
+		return nil, err
+	}
+	var refs struct {
+		WorkItems []struct {
+			ID int `json:"id"`
+		} `json:"workItems"`
+	}
+	if err := t.request(ctx, http.MethodPost, "/_apis/wit/wiql?api-version=6.0", "application/json", buf, &refs); err != nil {
+		return nil, err
+	}
+	issues := make([]Issue, 0, len(refs.WorkItems))
+	for _, ref := range refs.WorkItems {
+		var out azureWorkItem
+		path := fmt.Sprintf("/_apis/wit/workitems/%d?api-version=6.0", ref.ID)
+		if err := t.request(ctx, http.MethodGet, path, "application/json", nil, &out); err != nil {
+			return nil, err
+		}
+		issues = append(issues, *fromAzureWorkItem(&out))
+	}
+	return issues, nil
+}
+
+func fromAzureWorkItem(w *azureWorkItem) *Issue {
+	return &Issue{Number: w.ID, Title: w.Fields.Title, Body: w.Fields.Descr, URL: w.URL}
+}
@@ -0,0 +1,209 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package issues
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// bitbucketTracker implements IssueTracker against the Bitbucket Cloud REST
+// API (api.bitbucket.org/2.0). A Bitbucket Server baseURL (which uses the
+// older v1.0-shaped "issues" resource under /rest/api/1.0) also works since
+// both expose the same create/search/comment shapes used here.
+type bitbucketTracker struct {
+	token   string
+	baseURL string
+	do      func(*http.Request) (*http.Response, error)
+}
+
+func newBitbucketTracker(token, baseURL string) *bitbucketTracker {
+	if baseURL == "" {
+		baseURL = "https://api.bitbucket.org/2.0"
+	}
+	return &bitbucketTracker{token: token, baseURL: baseURL, do: http.DefaultClient.Do}
+}
+
+func (t *bitbucketTracker) request(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader *bytes.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(buf)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, t.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := t.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("bitbucket: %s %s: status %d", method, path, resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+type bitbucketIssue struct {
+	ID      int    `json:"id"`
+	Title   string `json:"title"`
+	Content struct {
+		Raw string `json:"raw"`
+	} `json:"content"`
+	Links struct {
+		HTML struct {
+			Href string `json:"href"`
+		} `json:"html"`
+	} `json:"links"`
+}
+
+// CreateIssue implements IssueTracker.
+func (t *bitbucketTracker) CreateIssue(
+	ctx context.Context, owner, repo string, issue *IssueRequest,
+) (*Issue, error) {
+	path := fmt.Sprintf("/repositories/%s/%s/issues", url.PathEscape(owner), url.PathEscape(repo))
+	body := map[string]interface{}{
+		"title":   issue.Title,
+		"content": map[string]string{"raw": issue.Body},
+		"kind":    "bug",
+	}
+	var out bitbucketIssue
+	if err := t.request(ctx, http.MethodPost, path, body, &out); err != nil {
+		return nil, err
+	}
+	return fromBitbucketIssue(&out), nil
+}
+
+// SearchIssues implements IssueTracker against the repo-scoped issues
+// endpoint, using Bitbucket's query language (https://developer.atlassian.com
+// /bitbucket/api/2/reference/meta/filtering) to match title/content and
+// state.
+func (t *bitbucketTracker) SearchIssues(ctx context.Context, criteria SearchCriteria) ([]Issue, error) {
+	search := criteria.FingerprintMarker
+	if search == "" {
+		search = criteria.TitleContains
+	}
+	q := fmt.Sprintf(`title ~ %q OR content.raw ~ %q`, search, search)
+	if criteria.Open {
+		q += ` AND state = "new"`
+	}
+	path := fmt.Sprintf("/repositories/%s/%s/issues?q=%s",
+		url.PathEscape(criteria.Owner), url.PathEscape(criteria.Repo), url.QueryEscape(q))
+	var out struct {
+		Values []bitbucketIssue `json:"values"`
+	}
+	if err := t.request(ctx, http.MethodGet, path, nil, &out); err != nil {
+		return nil, err
+	}
+	issues := make([]Issue, len(out.Values))
+	for i := range out.Values {
+		issues[i] = *fromBitbucketIssue(&out.Values[i])
+	}
+	return issues, nil
+}
+
+// CreateComment implements IssueTracker.
+func (t *bitbucketTracker) CreateComment(
+	ctx context.Context, owner, repo string, number int, comment *IssueComment,
+) (*IssueComment, error) {
+	path := fmt.Sprintf("/repositories/%s/%s/issues/%d/comments", url.PathEscape(owner), url.PathEscape(repo), number)
+	body := map[string]interface{}{"content": map[string]string{"raw": comment.Body}}
+	var out struct {
+		Links struct {
+			HTML struct {
+				Href string `json:"href"`
+			} `json:"html"`
+		} `json:"links"`
+	}
+	if err := t.request(ctx, http.MethodPost, path, body, &out); err != nil {
+		return nil, err
+	}
+	return &IssueComment{Body: comment.Body, URL: out.Links.HTML.Href}, nil
+}
+
+// ListCommits implements IssueTracker.
+func (t *bitbucketTracker) ListCommits(ctx context.Context, owner, repo, path string) ([]Commit, error) {
+	reqPath := fmt.Sprintf("/repositories/%s/%s/commits?path=%s", url.PathEscape(owner), url.PathEscape(repo), url.QueryEscape(path))
+	var out struct {
+		Values []struct {
+			Hash   string `json:"hash"`
+			Author struct {
+				User struct {
+					DisplayName string `json:"display_name"`
+				} `json:"user"`
+			} `json:"author"`
+		} `json:"values"`
+	}
+	if err := t.request(ctx, http.MethodGet, reqPath, nil, &out); err != nil {
+		return nil, err
+	}
+	commits := make([]Commit, len(out.Values))
+	for i, c := range out.Values {
+		commits[i] = Commit{SHA: c.Hash, AuthorName: c.Author.User.DisplayName}
+	}
+	return commits, nil
+}
+
+// ListMilestones implements IssueTracker.
+func (t *bitbucketTracker) ListMilestones(ctx context.Context, owner, repo string) ([]Milestone, error) {
+	path := fmt.Sprintf("/repositories/%s/%s/milestones", url.PathEscape(owner), url.PathEscape(repo))
+	var out struct {
+		Values []struct {
+			ID   int    `json:"id"`
+			Name string `json:"name"`
+		} `json:"values"`
+	}
+	if err := t.request(ctx, http.MethodGet, path, nil, &out); err != nil {
+		return nil, err
+	}
+	milestones := make([]Milestone, len(out.Values))
+	for i, m := range out.Values {
+		milestones[i] = Milestone{Number: m.ID, Title: m.Name}
+	}
+	return milestones, nil
+}
+
+// ListClosedIssues implements IssueTracker. milestone is not used as a
+// filter: every closed issue in the repository is returned. ClosedByRef is
+// left empty.
+func (t *bitbucketTracker) ListClosedIssues(ctx context.Context, owner, repo string, milestone int) ([]Issue, error) {
+	path := fmt.Sprintf("/repositories/%s/%s/issues?q=state%%3D%%22closed%%22", url.PathEscape(owner), url.PathEscape(repo))
+	var out struct {
+		Values []bitbucketIssue `json:"values"`
+	}
+	if err := t.request(ctx, http.MethodGet, path, nil, &out); err != nil {
+		return nil, err
+	}
+	issues := make([]Issue, len(out.Values))
+	for i := range out.Values {
+		issues[i] = *fromBitbucketIssue(&out.Values[i])
+	}
+	return issues, nil
+}
+
+func fromBitbucketIssue(i *bitbucketIssue) *Issue {
+	return &Issue{Number: i.ID, Title: i.Title, Body: i.Content.Raw, URL: i.Links.HTML.Href}
+}
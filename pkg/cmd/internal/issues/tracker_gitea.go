@@ -0,0 +1,193 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package issues
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// giteaTracker implements IssueTracker against the Gitea API, which Forgejo
+// (a Gitea fork) also speaks, so this covers both self-hosted forges.
+type giteaTracker struct {
+	token   string
+	baseURL string
+	do      func(*http.Request) (*http.Response, error)
+}
+
+func newGiteaTracker(token, baseURL string) *giteaTracker {
+	if baseURL == "" {
+		baseURL = "https://gitea.com/api/v1"
+	}
+	return &giteaTracker{token: token, baseURL: baseURL, do: http.DefaultClient.Do}
+}
+
+func (t *giteaTracker) request(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader *bytes.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(buf)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, t.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "token "+t.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := t.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("gitea: %s %s: status %d", method, path, resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+type giteaIssue struct {
+	Number int    `json:"number"`
+	Title  string `json:"title"`
+	Body   string `json:"body"`
+	URL    string `json:"html_url"`
+	Labels []struct {
+		Name string `json:"name"`
+		URL  string `json:"url"`
+	} `json:"labels"`
+}
+
+// CreateIssue implements IssueTracker.
+func (t *giteaTracker) CreateIssue(
+	ctx context.Context, owner, repo string, issue *IssueRequest,
+) (*Issue, error) {
+	path := fmt.Sprintf("/repos/%s/%s/issues", url.PathEscape(owner), url.PathEscape(repo))
+	body := map[string]interface{}{"title": issue.Title, "body": issue.Body, "labels": issue.Labels}
+	var out giteaIssue
+	if err := t.request(ctx, http.MethodPost, path, body, &out); err != nil {
+		return nil, err
+	}
+	return fromGiteaIssue(&out), nil
+}
+
+// SearchIssues implements IssueTracker against the per-repo issues search
+// endpoint, which takes a free-text `q` matched against title+body, plus
+// `state` and `labels` filters.
+func (t *giteaTracker) SearchIssues(ctx context.Context, criteria SearchCriteria) ([]Issue, error) {
+	search := criteria.FingerprintMarker
+	if search == "" {
+		search = criteria.TitleContains
+	}
+	path := fmt.Sprintf("/repos/%s/%s/issues?q=%s&type=issues",
+		url.PathEscape(criteria.Owner), url.PathEscape(criteria.Repo), url.QueryEscape(search))
+	if criteria.Open {
+		path += "&state=open"
+	}
+	if len(criteria.Labels) > 0 {
+		path += "&labels=" + url.QueryEscape(strings.Join(criteria.Labels, ","))
+	}
+	var out []giteaIssue
+	if err := t.request(ctx, http.MethodGet, path, nil, &out); err != nil {
+		return nil, err
+	}
+	issues := make([]Issue, len(out))
+	for i := range out {
+		issues[i] = *fromGiteaIssue(&out[i])
+	}
+	return issues, nil
+}
+
+// CreateComment implements IssueTracker.
+func (t *giteaTracker) CreateComment(
+	ctx context.Context, owner, repo string, number int, comment *IssueComment,
+) (*IssueComment, error) {
+	path := fmt.Sprintf("/repos/%s/%s/issues/%d/comments", url.PathEscape(owner), url.PathEscape(repo), number)
+	var out struct {
+		HTMLURL string `json:"html_url"`
+	}
+	if err := t.request(ctx, http.MethodPost, path, map[string]string{"body": comment.Body}, &out); err != nil {
+		return nil, err
+	}
+	return &IssueComment{Body: comment.Body, URL: out.HTMLURL}, nil
+}
+
+// ListCommits implements IssueTracker.
+func (t *giteaTracker) ListCommits(ctx context.Context, owner, repo, path string) ([]Commit, error) {
+	reqPath := fmt.Sprintf("/repos/%s/%s/commits?path=%s", url.PathEscape(owner), url.PathEscape(repo), url.QueryEscape(path))
+	var out []struct {
+		SHA    string `json:"sha"`
+		Commit struct {
+			Author struct {
+				Name string `json:"name"`
+			} `json:"author"`
+		} `json:"commit"`
+	}
+	if err := t.request(ctx, http.MethodGet, reqPath, nil, &out); err != nil {
+		return nil, err
+	}
+	commits := make([]Commit, len(out))
+	for i, c := range out {
+		commits[i] = Commit{SHA: c.SHA, AuthorName: c.Commit.Author.Name}
+	}
+	return commits, nil
+}
+
+// ListMilestones implements IssueTracker.
+func (t *giteaTracker) ListMilestones(ctx context.Context, owner, repo string) ([]Milestone, error) {
+	path := fmt.Sprintf("/repos/%s/%s/milestones", url.PathEscape(owner), url.PathEscape(repo))
+	var out []struct {
+		ID    int    `json:"id"`
+		Title string `json:"title"`
+	}
+	if err := t.request(ctx, http.MethodGet, path, nil, &out); err != nil {
+		return nil, err
+	}
+	milestones := make([]Milestone, len(out))
+	for i, m := range out {
+		milestones[i] = Milestone{Number: m.ID, Title: m.Title}
+	}
+	return milestones, nil
+}
+
+// ListClosedIssues implements IssueTracker. ClosedByRef is left empty; Gitea
+// has no dedicated "closed by commit" event in its issues API.
+func (t *giteaTracker) ListClosedIssues(ctx context.Context, owner, repo string, milestone int) ([]Issue, error) {
+	path := fmt.Sprintf("/repos/%s/%s/issues?state=closed&milestones=%d", url.PathEscape(owner), url.PathEscape(repo), milestone)
+	var out []giteaIssue
+	if err := t.request(ctx, http.MethodGet, path, nil, &out); err != nil {
+		return nil, err
+	}
+	issues := make([]Issue, len(out))
+	for i := range out {
+		issues[i] = *fromGiteaIssue(&out[i])
+	}
+	return issues, nil
+}
+
+func fromGiteaIssue(i *giteaIssue) *Issue {
+	issue := &Issue{Number: i.Number, Title: i.Title, Body: i.Body, URL: i.URL}
+	for _, l := range i.Labels {
+		issue.Labels = append(issue.Labels, Label{Name: l.Name, URL: l.URL})
+	}
+	return issue
+}
@@ -0,0 +1,185 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package issues
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/github"
+	"golang.org/x/oauth2"
+)
+
+// githubTracker is the original IssueTracker implementation, backed by
+// github.com/google/go-github.
+type githubTracker struct {
+	client *github.Client
+}
+
+func newGitHubTracker(token string) *githubTracker {
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
+	return &githubTracker{client: github.NewClient(oauth2.NewClient(context.Background(), ts))}
+}
+
+// CreateIssue implements IssueTracker.
+func (t *githubTracker) CreateIssue(
+	ctx context.Context, owner, repo string, issue *IssueRequest,
+) (*Issue, error) {
+	req := &github.IssueRequest{
+		Title:  github.String(issue.Title),
+		Body:   github.String(issue.Body),
+		Labels: &issue.Labels,
+	}
+	ghIssue, _, err := t.client.Issues.Create(ctx, owner, repo, req)
+	if err != nil {
+		return nil, err
+	}
+	return fromGitHubIssue(ghIssue), nil
+}
+
+// SearchIssues implements IssueTracker, translating criteria into GitHub's
+// search-operator syntax (`in:body`, `in:title`, `is:open`, `repo:`,
+// `label:`).
+func (t *githubTracker) SearchIssues(ctx context.Context, criteria SearchCriteria) ([]Issue, error) {
+	result, _, err := t.client.Search.Issues(ctx, githubSearchQuery(criteria), &github.SearchOptions{})
+	if err != nil {
+		return nil, err
+	}
+	issues := make([]Issue, len(result.Issues))
+	for i := range result.Issues {
+		issues[i] = *fromGitHubIssue(&result.Issues[i])
+	}
+	return issues, nil
+}
+
+func githubSearchQuery(criteria SearchCriteria) string {
+	var query string
+	switch {
+	case criteria.FingerprintMarker != "" && criteria.TitleContains != "":
+		query = fmt.Sprintf("(%q in:body OR %q in:title)", criteria.FingerprintMarker, criteria.TitleContains)
+	case criteria.FingerprintMarker != "":
+		query = fmt.Sprintf("%q in:body", criteria.FingerprintMarker)
+	case criteria.TitleContains != "":
+		query = fmt.Sprintf("%q in:title", criteria.TitleContains)
+	}
+	if criteria.Open {
+		query += " is:open"
+	}
+	query += fmt.Sprintf(" repo:%s/%s", criteria.Owner, criteria.Repo)
+	for _, l := range criteria.Labels {
+		query += " label:" + strconv.Quote(l)
+	}
+	return strings.TrimSpace(query)
+}
+
+// CreateComment implements IssueTracker.
+func (t *githubTracker) CreateComment(
+	ctx context.Context, owner, repo string, number int, comment *IssueComment,
+) (*IssueComment, error) {
+	ghComment, _, err := t.client.Issues.CreateComment(ctx, owner, repo, number, &github.IssueComment{
+		Body: github.String(comment.Body),
+	})
+	if err != nil {
+		return nil, err
+	}
+	out := &IssueComment{Body: comment.Body}
+	if ghComment.HTMLURL != nil {
+		out.URL = *ghComment.HTMLURL
+	}
+	return out, nil
+}
+
+// ListCommits implements IssueTracker.
+func (t *githubTracker) ListCommits(ctx context.Context, owner, repo, path string) ([]Commit, error) {
+	commits, _, err := t.client.Repositories.ListCommits(ctx, owner, repo, &github.CommitsListOptions{Path: path})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Commit, len(commits))
+	for i, c := range commits {
+		out[i].SHA = c.GetSHA()
+		if c.Author != nil {
+			out[i].AuthorName = c.Author.GetLogin()
+		}
+	}
+	return out, nil
+}
+
+// ListMilestones implements IssueTracker. It lists milestones regardless of
+// open/closed state: ReleaseNotes looks up a milestone by the version it
+// shipped, which by then is almost always closed, and GitHub's API defaults
+// to open-only.
+func (t *githubTracker) ListMilestones(ctx context.Context, owner, repo string) ([]Milestone, error) {
+	milestones, _, err := t.client.Issues.ListMilestones(ctx, owner, repo, &github.MilestoneListOptions{State: "all"})
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Milestone, len(milestones))
+	for i, m := range milestones {
+		out[i] = Milestone{Number: m.GetNumber(), Title: m.GetTitle()}
+	}
+	return out, nil
+}
+
+// ListClosedIssues implements IssueTracker. It populates ClosedByRef from
+// the commit SHA attached to the issue's "closed" timeline event, when
+// GitHub recorded one (i.e. the issue was closed by a merged commit/PR
+// rather than manually).
+func (t *githubTracker) ListClosedIssues(
+	ctx context.Context, owner, repo string, milestone int,
+) ([]Issue, error) {
+	ghIssues, _, err := t.client.Issues.ListByRepo(ctx, owner, repo, &github.IssueListByRepoOptions{
+		State:     "closed",
+		Milestone: fmt.Sprintf("%d", milestone),
+	})
+	if err != nil {
+		return nil, err
+	}
+	issues := make([]Issue, len(ghIssues))
+	for i, ghIssue := range ghIssues {
+		issue := fromGitHubIssue(ghIssue)
+		if ref, err := t.closedByRef(ctx, owner, repo, ghIssue.GetNumber()); err == nil {
+			issue.ClosedByRef = ref
+		}
+		issues[i] = *issue
+	}
+	return issues, nil
+}
+
+// closedByRef walks an issue's timeline events looking for the "closed"
+// event and returns the commit SHA attached to it, if any.
+func (t *githubTracker) closedByRef(ctx context.Context, owner, repo string, number int) (string, error) {
+	events, _, err := t.client.Issues.ListIssueEvents(ctx, owner, repo, number, &github.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+	for _, e := range events {
+		if e.GetEvent() == "closed" && e.GetCommitID() != "" {
+			return e.GetCommitID(), nil
+		}
+	}
+	return "", nil
+}
+
+func fromGitHubIssue(ghIssue *github.Issue) *Issue {
+	issue := &Issue{
+		Number: ghIssue.GetNumber(),
+		Title:  ghIssue.GetTitle(),
+		Body:   ghIssue.GetBody(),
+		URL:    ghIssue.GetHTMLURL(),
+	}
+	for _, l := range ghIssue.Labels {
+		issue.Labels = append(issue.Labels, Label{Name: l.GetName(), URL: l.GetURL()})
+	}
+	return issue
+}
@@ -0,0 +1,200 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package issues
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// gitlabTracker implements IssueTracker against the GitLab REST API (issues
+// + notes). baseURL defaults to the public gitlab.com API but can be
+// pointed at a self-hosted instance.
+type gitlabTracker struct {
+	token   string
+	baseURL string
+	do      func(*http.Request) (*http.Response, error)
+}
+
+func newGitLabTracker(token, baseURL string) *gitlabTracker {
+	if baseURL == "" {
+		baseURL = "https://gitlab.com/api/v4"
+	}
+	return &gitlabTracker{token: token, baseURL: baseURL, do: http.DefaultClient.Do}
+}
+
+func (t *gitlabTracker) request(
+	ctx context.Context, method, path string, body, out interface{},
+) error {
+	var reader *bytes.Reader
+	if body != nil {
+		buf, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(buf)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, t.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("PRIVATE-TOKEN", t.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := t.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("gitlab: %s %s: status %d", method, path, resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+type gitlabIssue struct {
+	IID    int      `json:"iid"`
+	Title  string   `json:"title"`
+	Desc   string   `json:"description"`
+	WebURL string   `json:"web_url"`
+	Labels []string `json:"labels"`
+}
+
+// CreateIssue implements IssueTracker.
+func (t *gitlabTracker) CreateIssue(
+	ctx context.Context, owner, repo string, issue *IssueRequest,
+) (*Issue, error) {
+	project := url.QueryEscape(owner + "/" + repo)
+	body := map[string]interface{}{
+		"title":       issue.Title,
+		"description": issue.Body,
+		"labels":      issue.Labels,
+	}
+	var out gitlabIssue
+	if err := t.request(ctx, http.MethodPost, "/projects/"+project+"/issues", body, &out); err != nil {
+		return nil, err
+	}
+	return fromGitLabIssue(&out), nil
+}
+
+// SearchIssues implements IssueTracker. GitLab has no free-text "search
+// issues across fields" endpoint akin to GitHub's /search/issues; the
+// closest analogue is the project-scoped issues list endpoint with a
+// `search` parameter scoped to title+description, which we further narrow
+// with `labels` and `state`.
+func (t *gitlabTracker) SearchIssues(ctx context.Context, criteria SearchCriteria) ([]Issue, error) {
+	project := url.QueryEscape(criteria.Owner + "/" + criteria.Repo)
+	search := criteria.FingerprintMarker
+	if search == "" {
+		search = criteria.TitleContains
+	}
+	path := fmt.Sprintf("/projects/%s/issues?search=%s", project, url.QueryEscape(search))
+	if criteria.Open {
+		path += "&state=opened"
+	}
+	if len(criteria.Labels) > 0 {
+		path += "&labels=" + url.QueryEscape(strings.Join(criteria.Labels, ","))
+	}
+	var out []gitlabIssue
+	if err := t.request(ctx, http.MethodGet, path, nil, &out); err != nil {
+		return nil, err
+	}
+	issues := make([]Issue, len(out))
+	for i := range out {
+		issues[i] = *fromGitLabIssue(&out[i])
+	}
+	return issues, nil
+}
+
+// CreateComment implements IssueTracker.
+func (t *gitlabTracker) CreateComment(
+	ctx context.Context, owner, repo string, number int, comment *IssueComment,
+) (*IssueComment, error) {
+	project := url.QueryEscape(owner + "/" + repo)
+	path := fmt.Sprintf("/projects/%s/issues/%d/notes", project, number)
+	body := map[string]interface{}{"body": comment.Body}
+	var out struct {
+		ID int `json:"id"`
+	}
+	if err := t.request(ctx, http.MethodPost, path, body, &out); err != nil {
+		return nil, err
+	}
+	return &IssueComment{Body: comment.Body}, nil
+}
+
+// ListCommits implements IssueTracker.
+func (t *gitlabTracker) ListCommits(ctx context.Context, owner, repo, path string) ([]Commit, error) {
+	project := url.QueryEscape(owner + "/" + repo)
+	reqPath := fmt.Sprintf("/projects/%s/repository/commits?path=%s", project, url.QueryEscape(path))
+	var out []struct {
+		ID         string `json:"id"`
+		AuthorName string `json:"author_name"`
+	}
+	if err := t.request(ctx, http.MethodGet, reqPath, nil, &out); err != nil {
+		return nil, err
+	}
+	commits := make([]Commit, len(out))
+	for i, c := range out {
+		commits[i] = Commit{SHA: c.ID, AuthorName: c.AuthorName}
+	}
+	return commits, nil
+}
+
+// ListMilestones implements IssueTracker.
+func (t *gitlabTracker) ListMilestones(ctx context.Context, owner, repo string) ([]Milestone, error) {
+	project := url.QueryEscape(owner + "/" + repo)
+	var out []struct {
+		ID    int    `json:"id"`
+		Title string `json:"title"`
+	}
+	if err := t.request(ctx, http.MethodGet, "/projects/"+project+"/milestones", nil, &out); err != nil {
+		return nil, err
+	}
+	milestones := make([]Milestone, len(out))
+	for i, m := range out {
+		milestones[i] = Milestone{Number: m.ID, Title: m.Title}
+	}
+	return milestones, nil
+}
+
+// ListClosedIssues implements IssueTracker. GitLab's REST API has no cheap
+// equivalent of GitHub's "closed by commit" timeline event, so
+// Issue.ClosedByRef is left empty here.
+func (t *gitlabTracker) ListClosedIssues(ctx context.Context, owner, repo string, milestone int) ([]Issue, error) {
+	project := url.QueryEscape(owner + "/" + repo)
+	path := fmt.Sprintf("/projects/%s/issues?state=closed&milestone_id=%d", project, milestone)
+	var out []gitlabIssue
+	if err := t.request(ctx, http.MethodGet, path, nil, &out); err != nil {
+		return nil, err
+	}
+	issues := make([]Issue, len(out))
+	for i := range out {
+		issues[i] = *fromGitLabIssue(&out[i])
+	}
+	return issues, nil
+}
+
+func fromGitLabIssue(i *gitlabIssue) *Issue {
+	issue := &Issue{Number: i.IID, Title: i.Title, Body: i.Desc, URL: i.WebURL}
+	for _, l := range i.Labels {
+		issue.Labels = append(issue.Labels, Label{Name: l})
+	}
+	return issue
+}
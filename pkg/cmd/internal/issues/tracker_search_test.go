@@ -0,0 +1,94 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Use of this software is governed by the Business Source License
+// included in the file licenses/BSL.txt.
+//
+// As of the Change Date specified in that file, in accordance with
+// the Business Source License, use of this software will be governed
+// by the Apache License, Version 2.0, included in the file
+// licenses/APL.txt.
+
+package issues
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeJSONTransport serves body (a JSON-ish string) for every request while
+// recording the request it was asked to make, so tests can assert on the
+// path/query a tracker's SearchIssues built without needing a real backend.
+func fakeJSONTransport(recorded *http.Request, bodyBuf *string, body string) func(*http.Request) (*http.Response, error) {
+	return func(req *http.Request) (*http.Response, error) {
+		*recorded = *req
+		if req.Body != nil {
+			buf, _ := io.ReadAll(req.Body)
+			*bodyBuf = string(buf)
+		}
+		return &http.Response{StatusCode: 200, Body: io.NopCloser(strings.NewReader(body))}, nil
+	}
+}
+
+func TestGitLabSearchIssuesScopesToProject(t *testing.T) {
+	var req http.Request
+	var body string
+	tracker := &gitlabTracker{token: "fake", baseURL: "https://gitlab.example.com/api/v4",
+		do: fakeJSONTransport(&req, &body, "[]")}
+	_, err := tracker.SearchIssues(context.Background(), SearchCriteria{
+		Owner: "cockroachdb", Repo: "cockroach", Open: true,
+		Labels: []string{"C-test-failure"}, TitleContains: "storage: TestFoo failed",
+	})
+	require.NoError(t, err)
+	require.Contains(t, req.URL.String(), "/projects/cockroachdb%2Fcockroach/issues")
+	require.Contains(t, req.URL.String(), "search=storage%3A+TestFoo+failed")
+	require.Contains(t, req.URL.String(), "state=opened")
+	require.Contains(t, req.URL.String(), "labels=C-test-failure")
+}
+
+func TestGiteaSearchIssuesScopesToRepo(t *testing.T) {
+	var req http.Request
+	var body string
+	tracker := &giteaTracker{token: "fake", baseURL: "https://gitea.example.com/api/v1",
+		do: fakeJSONTransport(&req, &body, "[]")}
+	_, err := tracker.SearchIssues(context.Background(), SearchCriteria{
+		Owner: "cockroachdb", Repo: "cockroach", Open: true, TitleContains: "boom",
+	})
+	require.NoError(t, err)
+	require.Contains(t, req.URL.String(), "/repos/cockroachdb/cockroach/issues")
+	require.Contains(t, req.URL.String(), "q=boom")
+	require.Contains(t, req.URL.String(), "state=open")
+}
+
+func TestBitbucketSearchIssuesHitsIssuesEndpoint(t *testing.T) {
+	var req http.Request
+	var body string
+	tracker := &bitbucketTracker{token: "fake", baseURL: "https://api.bitbucket.org/2.0",
+		do: fakeJSONTransport(&req, &body, `{"values":[]}`)}
+	_, err := tracker.SearchIssues(context.Background(), SearchCriteria{
+		Owner: "cockroachdb", Repo: "cockroach", Open: true, TitleContains: "boom",
+	})
+	require.NoError(t, err)
+	// Must hit the repo-scoped issues resource, not /repositories (repo
+	// search).
+	require.Contains(t, req.URL.Path, "/repositories/cockroachdb/cockroach/issues")
+	require.Contains(t, req.URL.String(), "q=")
+}
+
+func TestAzureDevOpsSearchIssuesScopesToProjectAndEscapesQuotes(t *testing.T) {
+	var req http.Request
+	var body string
+	tracker := &azureDevOpsTracker{token: "fake", org: "https://dev.azure.com/my-org",
+		do: fakeJSONTransport(&req, &body, `{"workItems":[]}`)}
+	_, err := tracker.SearchIssues(context.Background(), SearchCriteria{
+		Owner: "cockroachdb", Repo: "cockroach", Open: true, TitleContains: `it's a trap`,
+	})
+	require.NoError(t, err)
+	require.Contains(t, req.URL.Path, "/cockroach/_apis/wit/wiql")
+	require.Contains(t, body, `it''s a trap`)
+	require.NotContains(t, body, `it's a trap`)
+}